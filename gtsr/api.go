@@ -30,9 +30,11 @@
 package gtsr
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
-	"math/rand"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -59,15 +61,36 @@ type SlackBot struct {
 	rtm       *slack.RTM
 	scheduler *cron.Cron
 	running   bool
-
-	users    map[string]*slack.User
-	channels map[string]*slack.Channel
-	ims      map[string]*slack.User
-
-	gm *GlobalMessenger
-
-	plugins []SlackPlugin
-
+	cancel    context.CancelFunc
+	conn      *connection
+	http      HTTPConfig
+
+	// Guards config, which is swapped out by Reload
+	configMutex *sync.RWMutex
+	config      *Config
+	// Keeps two reloads from running at once
+	reloadMutex *sync.Mutex
+
+	// Guards users, channels and ims, which refreshData swaps out while
+	// workers and HTTP handlers read them
+	dataMutex *sync.RWMutex
+	users     map[string]*slack.User
+	channels  map[string]*slack.Channel
+	ims       map[string]*slack.User
+
+	gm         *GlobalMessenger
+	dispatcher *dispatcher
+
+	plugins     []*loadedPlugin
+	middleware  []Middleware
+	permissions func(user string) *Permissions
+
+	// How adding each plugin went, in order
+	results []pluginResult
+	// Plugins turned off by admins
+	switches *pluginSwitches
+
+	// Keyed by "pluginid.topicid", always lowercase
 	topics map[string]*ConvoTopic
 	crons  map[string]*CronJob
 }
@@ -84,13 +107,18 @@ type SlackPlugin interface {
 	Teardown()
 
 	// ParseMessage is called for every new message sent in a
-	// channel the SlackBot is a member of
+	// channel the SlackBot is a member of. It runs concurrently, both
+	// with messages in other channels and with the other plugins
+	// handling the same message, so it must be threadsafe
 	ParseMessage(*IncomingMessage, *Messenger) error
 }
 
 // A PluginConfig describes the attributes of a plugin, which features
 // it uses, and where to send requests for those features
 type PluginConfig struct {
+	// Unique ID for the plugin - only alphanumeric. Used to namespace
+	// the plugin's topics
+	ID string
 	// Full name of the plugin, this may includes spaces and special characters
 	Name string
 	// Description of the plugin's functionality
@@ -109,6 +137,14 @@ type PluginConfig struct {
 	// List of registered Cron Jobs for this plugin - must be
 	// non empty if the feature is enabled
 	Jobs []*CronJob
+
+	// Channel messages the plugin wants to hear about. These run in
+	// addition to ParseMessage
+	Handlers []*MessageHandler
+
+	// How long ParseMessage and Handlers get to handle a message before
+	// the channel moves on without them. Defaults to 10 seconds
+	Timeout time.Duration
 }
 
 // A Permissions struct enumerates which permissions are available within
@@ -124,24 +160,42 @@ type Permissions struct {
 // verificationToken is the shared secret provided by slack to verify
 // the authenticity of interactive message callbacks
 func InitSlack(key string, verificationToken string) *SlackBot {
-	// TODO(nussey): Move off of the global RNG
-	rand.Seed(time.Now().UnixNano())
-
 	bot := &SlackBot{
 		apikey: key,
 		token:  verificationToken,
 		api:    slack.New(key),
 
+		dispatcher:  newDispatcher(defaultWorkers),
+		conn:        &connection{mutex: &sync.Mutex{}},
+		configMutex: &sync.RWMutex{},
+		reloadMutex: &sync.Mutex{},
+		dataMutex:   &sync.RWMutex{},
+		switches:    newPluginSwitches(),
+		http: HTTPConfig{
+			Addr:            ":8080",
+			InteractionPath: "/",
+			SlashPath:       "/slash",
+			OptionsPath:     "/options",
+		},
+
 		topics: make(map[string]*ConvoTopic),
 		crons:  make(map[string]*CronJob),
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	bot.cancel = cancel
+
 	bot.rtm = bot.api.NewRTM()
 	bot.gm = &GlobalMessenger{
-		API: bot.api,
-		dms: make(map[string]*directMessage),
+		API:     bot.api,
+		apikey:  key,
+		ctx:     ctx,
+		idMutex: &sync.RWMutex{},
+		dmMutex: &sync.RWMutex{},
+		dms:     make(map[string]*directMessage),
 		listener: &callbackListener{
-			callbacks: make(map[string]*Messenger),
+			callbacks: make(map[string]*OutgoingMessage),
+			dialogs:   make(map[string]*pendingDialog),
 			mutex:     &sync.Mutex{},
 		},
 	}
@@ -150,38 +204,84 @@ func InitSlack(key string, verificationToken string) *SlackBot {
 }
 
 // AddPlugin registeres a plugin with the Slack Bot. Make
-// all of these calls before ServeSlack()
-func (sb *SlackBot) AddPlugin(plugin SlackPlugin) {
+// all of these calls before ServeSlack(). A plugin that breaks the
+// rules of PluginConfig isn't added, and the returned *PluginError
+// says why. Either way it shows up in the StartupReport
+func (sb *SlackBot) AddPlugin(plugin SlackPlugin) error {
+	return sb.addPlugin(plugin, "")
+}
+
+// addPlugin is AddPlugin for a plugin that must have the given ID, or
+// any ID if it is empty
+func (sb *SlackBot) addPlugin(plugin SlackPlugin, id string) error {
 	if sb.running {
-		panic("Register plugins before starting the Slack Bot")
+		return fmt.Errorf("register plugins before starting the Slack Bot")
 	}
 
 	config := plugin.Init()
+	if config == nil {
+		err := &PluginError{Plugin: fmt.Sprintf("%T", plugin), Problems: []string{"Init returned no config"}}
+		sb.results = append(sb.results, pluginResult{err: err})
+		return err
+	}
+
+	if perr := sb.validatePlugin(config, id); perr != nil {
+		sb.results = append(sb.results, pluginResult{name: config.Name, err: perr})
+		return perr
+	}
+
 	if config.FeatureConvo {
 		for _, topic := range config.Topics {
-			if _, ok := sb.topics[topic.Label]; ok {
-				panic("Can't load multiple plugins that use the same conversation label")
-			}
-			sb.topics[topic.Label] = topic
+			topic.key = strings.ToLower(config.ID + "." + topic.ID)
+			sb.topics[topic.key] = topic
 		}
 	}
 
 	if config.FeatureCron {
 		for _, cron := range config.Jobs {
-			if _, ok := sb.crons[cron.ID]; ok {
-				panic("Can't load multiple plugins that use the same cron ID")
-			}
 			sb.crons[cron.ID] = cron
 		}
 	}
 
-	sb.plugins = append(sb.plugins, plugin)
+	sb.plugins = append(sb.plugins, &loadedPlugin{plugin: plugin, config: config})
+	sb.results = append(sb.results, pluginResult{name: fmt.Sprintf("%s (%s) v%s", config.Name, config.ID, config.Version)})
+	return nil
+}
+
+// SetQueuePolicy chooses what happens when a user has too many
+// conversations waiting. The default is QueueReject. Call this
+// before ServeSlack()
+func (sb *SlackBot) SetQueuePolicy(policy QueuePolicy) {
+	if sb.running {
+		panic("Set the queue policy before starting the Slack Bot")
+	}
+
+	sb.gm.queuePolicy = policy
 }
 
+// SortedConvoTopics returns the labels of every registered topic
+// in alphabetical order
 func (sb *SlackBot) SortedConvoTopics() []string {
 	var labels []string
-	for k := range sb.topics {
-		labels = append(labels, k)
+	for _, topic := range sb.topics {
+		labels = append(labels, topic.Label)
+	}
+
+	sort.Strings(labels)
+
+	return labels
+}
+
+// allowedConvoTopics is SortedConvoTopics without the topics the user
+// isn't allowed to start
+func (sb *SlackBot) allowedConvoTopics(user string) []string {
+	perms := sb.Permissions(user)
+
+	var labels []string
+	for _, topic := range sb.topics {
+		if perms.allows(topic) {
+			labels = append(labels, topic.Label)
+		}
 	}
 
 	sort.Strings(labels)
@@ -190,38 +290,122 @@ func (sb *SlackBot) SortedConvoTopics() []string {
 }
 
 func (sb *SlackBot) refreshData() {
-	sb.fetchChannels()
-	sb.fetchUsers()
-	sb.fetchIMs()
+	channels := sb.fetchChannels()
+	users := sb.fetchUsers()
+	ims := sb.fetchIMs(users)
+
+	sb.dataMutex.Lock()
+	sb.users = users
+	sb.channels = channels
+	sb.ims = ims
+	sb.dataMutex.Unlock()
+
+	sb.initDms(users)
+
+	sb.gm.mapIds(ims)
+	sb.gm.mapChannels(channels)
+}
 
-	sb.initDms()
+// user looks up a user by Slack ID
+func (sb *SlackBot) user(id string) (*slack.User, bool) {
+	sb.dataMutex.RLock()
+	defer sb.dataMutex.RUnlock()
 
-	sb.gm.mapIds(sb.ims)
+	user, ok := sb.users[id]
+	return user, ok
+}
+
+// userName returns the name of the user with the Slack ID, or "" if
+// there is no such user
+func (sb *SlackBot) userName(id string) string {
+	if user, ok := sb.user(id); ok {
+		return user.Name
+	}
+	return ""
+}
+
+// channelName returns the name of the channel with the Slack ID, or ""
+// if there is no such channel
+func (sb *SlackBot) channelName(id string) string {
+	sb.dataMutex.RLock()
+	defer sb.dataMutex.RUnlock()
+
+	if channel, ok := sb.channels[id]; ok {
+		return channel.Name
+	}
+	return ""
+}
+
+// channelByName returns the Slack ID of the channel with the name
+func (sb *SlackBot) channelByName(name string) (string, bool) {
+	sb.dataMutex.RLock()
+	defer sb.dataMutex.RUnlock()
+
+	for id, channel := range sb.channels {
+		if channel.Name == name {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// imUser returns the user on the other end of a DM channel
+func (sb *SlackBot) imUser(id string) (*slack.User, bool) {
+	sb.dataMutex.RLock()
+	defer sb.dataMutex.RUnlock()
+
+	user, ok := sb.ims[id]
+	return user, ok
 }
 
 // ServeSlack is a blocking function that handles all network transactions
-// for the Slack Bot instance
+// for the Slack Bot instance. It fails right away if the HTTP server for
+// Slack callbacks can't start
 func (sb *SlackBot) ServeSlack() error {
 	// TODO(nussey) fix race condition
 	if sb.running {
 		panic("There is already an instance of this Slack Bot running! Create a new instance to run two concurrently!")
 	}
 
+	err := sb.handleInteractiveMessages()
+	if err != nil {
+		return err
+	}
+
 	sb.running = true
+	sb.conn.started = time.Now()
 
 	go sb.rtm.ManageConnection()
-	go sb.handleInteractiveMessages()
+	go sb.gm.listener.sweep(sb.gm.ctx)
+	sb.dispatcher.start(sb.gm.ctx)
+	if sb.config != nil {
+		go sb.reloadOnSignal(sb.gm.ctx)
+	}
 
 	sb.initCron()
 
-	for msg := range sb.rtm.IncomingEvents {
+	for {
+		var msg slack.RTMEvent
+		select {
+		case msg = <-sb.rtm.IncomingEvents:
+		case <-sb.gm.ctx.Done():
+			return nil
+		}
+
 		switch ev := msg.Data.(type) {
 		case *slack.HelloEvent:
 			sb.refreshData()
 
 		case *slack.ConnectedEvent:
 			sb.refreshData()
+			sb.conn.up(ev.ConnectionCount)
 			fmt.Println("We are off!")
+			if ev.ConnectionCount == 1 {
+				sb.report()
+			}
+
+		case *slack.DisconnectedEvent:
+			sb.conn.down()
 
 		case *slack.MessageEvent:
 			if ev.User == sb.rtm.GetInfo().User.ID {
@@ -236,7 +420,9 @@ func (sb *SlackBot) ServeSlack() error {
 			msgType := ev.Channel[0]
 
 			if msgType == chanMsg {
-				sb.parseMessage(ev)
+				sb.dispatcher.submit(ev.Channel, func() {
+					sb.parseMessage(ev)
+				})
 			}
 			if msgType == dm {
 				sb.dispatchConversation(ev)
@@ -259,77 +445,122 @@ func (sb *SlackBot) ServeSlack() error {
 			// Ignore other events..
 		}
 	}
-	return nil
+}
+
+// Shutdown stops the Slack Bot. Every running conversation is cancelled,
+// cron jobs stop firing, plugins are torn down and ServeSlack returns
+func (sb *SlackBot) Shutdown() {
+	sb.cancel()
+
+	if sb.scheduler != nil {
+		sb.scheduler.Stop()
+	}
+	sb.rtm.Disconnect()
+
+	for _, lp := range sb.plugins {
+		lp.plugin.Teardown()
+	}
 }
 
 func (sb *SlackBot) parseMessage(ev *slack.MessageEvent) {
-	channel := sb.channels[ev.Channel]
-	scopedMessenger := sb.gm.scope(channel.Name)
+	event := &Event{
+		Kind:    EventMessage,
+		User:    sb.userName(ev.User),
+		Channel: sb.channelName(ev.Channel),
+		Text:    ev.Text,
+		Bot:     ev.BotID != "" || ev.SubType == "bot_message",
+	}
+
+	err := sb.handle(event, func(*Event) error {
+		sb.dispatchMessage(ev)
+		return nil
+	})
+	if err != nil && denial(err) == "" {
+		fmt.Println(err)
+	}
+}
+
+// dispatchMessage fans a channel message out to every plugin
+func (sb *SlackBot) dispatchMessage(ev *slack.MessageEvent) {
+	if sb.mentionDeepLink(ev) || sb.mentionHelp(ev) || sb.mentionReload(ev) {
+		return
+	}
 
 	msg := &IncomingMessage{
 		Text: ev.Text,
 
+		user:      sb.userName(ev.User),
 		channel:   ev.Channel,
 		timestamp: ev.Timestamp,
 
 		sb: sb,
 	}
 
-	for _, plugin := range sb.plugins {
-		// TODO(nussey): much better error handling
-		err := plugin.ParseMessage(msg, scopedMessenger)
-		if err != nil {
-			fmt.Println(err)
-		}
-	}
+	sb.callPlugins(msg)
 }
 
-func (sb *SlackBot) fetchUsers() {
-	sb.users = make(map[string]*slack.User)
+func (sb *SlackBot) fetchUsers() map[string]*slack.User {
+	byID := make(map[string]*slack.User)
 	users := sb.rtm.GetInfo().Users
 
 	for user := range users {
-		sb.users[users[user].ID] = &users[user]
+		byID[users[user].ID] = &users[user]
 	}
+	return byID
 }
 
-func (sb *SlackBot) fetchChannels() {
-	sb.channels = make(map[string]*slack.Channel)
+func (sb *SlackBot) fetchChannels() map[string]*slack.Channel {
+	byID := make(map[string]*slack.Channel)
 	chans := sb.rtm.GetInfo().Channels
 
 	for channel := range chans {
-		sb.channels[chans[channel].ID] = &chans[channel]
+		byID[chans[channel].ID] = &chans[channel]
 	}
+	return byID
 }
 
-func (sb *SlackBot) fetchIMs() {
-	sb.ims = make(map[string]*slack.User)
+func (sb *SlackBot) fetchIMs(users map[string]*slack.User) map[string]*slack.User {
+	byID := make(map[string]*slack.User)
 	ims := sb.rtm.GetInfo().IMs
 
 	for im := range ims {
-		sb.ims[ims[im].ID] = sb.users[ims[im].User]
+		if user, ok := users[ims[im].User]; ok {
+			byID[ims[im].ID] = user
+		}
 	}
+	return byID
 }
 
+// randStringRunes returns n crypto-random alphanumeric characters
 func randStringRunes(n int) string {
-	// TODO(nussey): Move off of the global RNG
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = rngRunes[rand.Intn(len(rngRunes))]
+	// Bytes past the largest multiple of len(rngRunes) are thrown away
+	// so that every rune is equally likely
+	limit := byte(256 - 256%len(rngRunes))
+
+	b := make([]rune, 0, n)
+	buf := make([]byte, n)
+	for len(b) < n {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		for _, c := range buf {
+			if c < limit && len(b) < n {
+				b = append(b, rngRunes[int(c)%len(rngRunes)])
+			}
+		}
 	}
 	return string(b)
 }
 
-func (sb *SlackBot) initDms() {
-	for _, user := range sb.users {
-		if _, ok := sb.gm.dms[user.Name]; !ok {
-			sb.gm.dms[user.Name] = &directMessage{
-				mutex: &sync.Mutex{},
+func (sb *SlackBot) initDms(users map[string]*slack.User) {
+	sb.gm.dmMutex.Lock()
+	defer sb.gm.dmMutex.Unlock()
 
-				currentConvo: nil,
-				convoQueue:   make(chan *conversation, convoQueueSize),
-			}
-			go sb.gm.dms[user.Name].manageDM()
+	for _, user := range users {
+		if _, ok := sb.gm.dms[user.Name]; !ok {
+			dm := newDirectMessage(sb.gm.ctx)
+			sb.gm.dms[user.Name] = dm
+			go dm.manageDM()
 		}
 	}
 }
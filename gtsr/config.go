@@ -0,0 +1,346 @@
+package gtsr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Environment variables that override secrets in the config file, so
+// they can be kept out of it
+const (
+	EnvAPIKey            = "GTSR_SLACK_API_KEY"
+	EnvVerificationToken = "GTSR_SLACK_VERIFICATION_TOKEN"
+	EnvHTTPAddr          = "GTSR_HTTP_ADDR"
+)
+
+// A Config is everything needed to run a SlackBot, usually read from a
+// JSON file with LoadConfig
+type Config struct {
+	Slack SlackConfig
+	HTTP  HTTPConfig
+
+	// Name of the channel where the SlackBot reports problems and
+	// takes admin commands
+	AdminChannel string
+	// Slack names of the users that get each set of Permissions
+	Roles RolesConfig
+
+	// Number of channel messages handled at once, 8 if zero
+	Workers int
+
+	// Where settings changed at runtime, like plugins turned off by
+	// admins, are saved. Defaults to "./state.json"
+	StateFile string
+
+	// Sections of the plugins, keyed by plugin ID
+	Plugins map[string]*PluginSection
+
+	// File the config was loaded from
+	path string
+}
+
+// SlackConfig holds the credentials of the Slack app
+type SlackConfig struct {
+	APIKey            string
+	VerificationToken string
+}
+
+// HTTPConfig says where to listen for callbacks from Slack. Empty
+// fields get the defaults
+type HTTPConfig struct {
+	// Defaults to ":8080"
+	Addr string
+	// Defaults to "/"
+	InteractionPath string
+	// Defaults to "/slash"
+	SlashPath string
+	// Defaults to "/options"
+	OptionsPath string
+}
+
+// RolesConfig lists who has which Permissions
+type RolesConfig struct {
+	Admin       []string
+	Exec        []string
+	SubteamLead []string
+}
+
+// A PluginSection turns a plugin on and holds its own settings
+type PluginSection struct {
+	Enabled bool
+	// Decoded into the Settings of a ConfigurablePlugin
+	Settings json.RawMessage
+}
+
+// A ConfigurablePlugin has settings in its section of the config file.
+// They are decoded into the value returned by Settings, which should
+// be a pointer to a struct, before Init is called. If that value has a
+// Validate() error method it is checked too
+type ConfigurablePlugin interface {
+	SlackPlugin
+	Settings() interface{}
+}
+
+// A ConfigError lists everything wrong with a config file
+type ConfigError struct {
+	Path     string
+	Problems []string
+}
+
+func (err *ConfigError) Error() string {
+	return fmt.Sprintf("bad config %s:\n  %s", err.Path, strings.Join(err.Problems, "\n  "))
+}
+
+func (err *ConfigError) add(format string, args ...interface{}) {
+	err.Problems = append(err.Problems, fmt.Sprintf(format, args...))
+}
+
+// LoadConfig reads the JSON config file at path, applies environment
+// overrides and validates it. Any problems are returned as a
+// *ConfigError
+func LoadConfig(path string) (*Config, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{path: path}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(cfg)
+	if err != nil {
+		return nil, &ConfigError{Path: path, Problems: []string{err.Error()}}
+	}
+
+	cfg.applyEnv()
+	cfg.applyDefaults()
+
+	cerr := cfg.validate()
+	if len(cerr.Problems) > 0 {
+		cerr.Path = path
+		return nil, cerr
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) applyEnv() {
+	if v := os.Getenv(EnvAPIKey); v != "" {
+		cfg.Slack.APIKey = v
+	}
+	if v := os.Getenv(EnvVerificationToken); v != "" {
+		cfg.Slack.VerificationToken = v
+	}
+	if v := os.Getenv(EnvHTTPAddr); v != "" {
+		cfg.HTTP.Addr = v
+	}
+}
+
+func (cfg *Config) applyDefaults() {
+	if cfg.HTTP.Addr == "" {
+		cfg.HTTP.Addr = ":8080"
+	}
+	if cfg.HTTP.InteractionPath == "" {
+		cfg.HTTP.InteractionPath = "/"
+	}
+	if cfg.HTTP.SlashPath == "" {
+		cfg.HTTP.SlashPath = "/slash"
+	}
+	if cfg.HTTP.OptionsPath == "" {
+		cfg.HTTP.OptionsPath = "/options"
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.StateFile == "" {
+		cfg.StateFile = "./state.json"
+	}
+}
+
+func (cfg *Config) validate() *ConfigError {
+	cerr := &ConfigError{}
+
+	if cfg.Slack.APIKey == "" {
+		cerr.add("Slack.APIKey is required (or set %s)", EnvAPIKey)
+	}
+	if cfg.Slack.VerificationToken == "" {
+		cerr.add("Slack.VerificationToken is required (or set %s)", EnvVerificationToken)
+	}
+
+	paths := []struct{ field, path string }{
+		{"HTTP.InteractionPath", cfg.HTTP.InteractionPath},
+		{"HTTP.SlashPath", cfg.HTTP.SlashPath},
+		{"HTTP.OptionsPath", cfg.HTTP.OptionsPath},
+	}
+	seen := make(map[string]string)
+	for _, p := range paths {
+		if !strings.HasPrefix(p.path, "/") {
+			cerr.add("%s must start with /, got %q", p.field, p.path)
+		}
+		if other, ok := seen[p.path]; ok {
+			cerr.add("%s and %s are both %q", other, p.field, p.path)
+		}
+		seen[p.path] = p.field
+	}
+
+	if strings.HasPrefix(cfg.AdminChannel, "#") {
+		cerr.add("AdminChannel should be a channel name without the #, got %q", cfg.AdminChannel)
+	}
+	if cfg.Workers < 0 {
+		cerr.add("Workers can't be negative, got %d", cfg.Workers)
+	}
+
+	for id, section := range cfg.Plugins {
+		if section == nil {
+			cerr.add("Plugins.%s must be an object", id)
+		}
+	}
+	return cerr
+}
+
+// EnabledPlugins returns the IDs of the enabled plugins in alphabetical
+// order
+func (cfg *Config) EnabledPlugins() []string {
+	var ids []string
+	for id, section := range cfg.Plugins {
+		if section.Enabled {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// permissions returns the Permissions of a user according to Roles
+func (roles *RolesConfig) permissions(user string) *Permissions {
+	return &Permissions{
+		Admin:       contains(roles.Admin, user),
+		Exec:        contains(roles.Exec, user),
+		SubteamLead: contains(roles.SubteamLead, user),
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSettings fills in the settings of a plugin from its section
+func decodeSettings(id string, plugin SlackPlugin, section *PluginSection) error {
+	cp, ok := plugin.(ConfigurablePlugin)
+	if !ok {
+		if len(section.Settings) > 0 {
+			return fmt.Errorf("Plugins.%s.Settings: plugin has no settings", id)
+		}
+		return nil
+	}
+
+	return decodeInto(id, cp.Settings(), section)
+}
+
+// decodeInto decodes the settings in section into settings, a pointer,
+// and validates them
+func decodeInto(id string, settings interface{}, section *PluginSection) error {
+	if len(section.Settings) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(section.Settings))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(settings)
+		if err != nil {
+			return fmt.Errorf("Plugins.%s.Settings: %s", id, err)
+		}
+	}
+
+	if v, ok := settings.(interface {
+		Validate() error
+	}); ok {
+		err := v.Validate()
+		if err != nil {
+			return fmt.Errorf("Plugins.%s.Settings: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// InitSlackConfig is InitSlack for a loaded Config. It also sets up the
+// HTTP server, roles and worker pool described by it
+func InitSlackConfig(cfg *Config) *SlackBot {
+	bot := InitSlack(cfg.Slack.APIKey, cfg.Slack.VerificationToken)
+
+	bot.config = cfg
+	bot.http = cfg.HTTP
+	bot.dispatcher = newDispatcher(cfg.Workers)
+	bot.SetPermissions(func(user string) *Permissions {
+		return bot.currentConfig().Roles.permissions(user)
+	})
+
+	switches, err := loadPluginSwitches(cfg.StateFile)
+	if err != nil {
+		// Leave the broken file alone and start with everything on
+		fmt.Println(err)
+	} else {
+		bot.switches = switches
+	}
+
+	return bot
+}
+
+// AddConfiguredPlugins adds every plugin enabled in the config, picked
+// from available by ID. The settings of each plugin are decoded before
+// it is added. A bad plugin is skipped and the rest are still added.
+// Every problem is reported in a single *ConfigError
+func (sb *SlackBot) AddConfiguredPlugins(available map[string]SlackPlugin) error {
+	if sb.config == nil {
+		return fmt.Errorf("the Slack Bot wasn't created from a config")
+	}
+
+	cerr := &ConfigError{Path: sb.config.path}
+	for _, id := range sb.config.EnabledPlugins() {
+		plugin, ok := available[id]
+		if !ok {
+			var known []string
+			for name := range available {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			err := fmt.Errorf("Plugins.%s: no such plugin, pick from %s", id, strings.Join(known, ", "))
+			sb.results = append(sb.results, pluginResult{err: err})
+			cerr.add("%s", err)
+			continue
+		}
+
+		err := decodeSettings(id, plugin, sb.config.Plugins[id])
+		if err != nil {
+			sb.results = append(sb.results, pluginResult{err: err})
+			cerr.add("%s", err)
+			continue
+		}
+
+		err = sb.addPlugin(plugin, id)
+		if err != nil {
+			cerr.add("Plugins.%s: %s", id, err)
+		}
+	}
+
+	if len(cerr.Problems) > 0 {
+		return cerr
+	}
+	return nil
+}
+
+// AdminChannel returns the name of the channel for admin reports and
+// commands, or "" if there is none
+func (sb *SlackBot) AdminChannel() string {
+	cfg := sb.currentConfig()
+	if cfg == nil {
+		return ""
+	}
+	return cfg.AdminChannel
+}
@@ -1,6 +1,9 @@
 package gtsr
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/nlopes/slack"
@@ -8,28 +11,110 @@ import (
 
 const convoQueueSize = 10
 
+// A QueuePolicy decides what happens when a conversation is started
+// with a user whose conversation queue is already full
+type QueuePolicy int
+
+const (
+	// QueueReject refuses the new conversation with an error
+	QueueReject QueuePolicy = iota
+	// QueueDropOldest throws away the oldest waiting conversation to
+	// make room for the new one
+	QueueDropOldest
+	// QueueMerge drops a new topic conversation if the same topic is
+	// already waiting in the queue, and otherwise rejects it
+	QueueMerge
+)
+
+// CancelPhrases are the messages a user can send to walk away from the
+// conversation they are currently having with the SlackBot
+var CancelPhrases = []string{"cancel", "stop", "nevermind", "never mind"}
+
+// ErrQueueFull is returned when a conversation could not be queued
+// because the user already has too many waiting
+var ErrQueueFull = fmt.Errorf("conversation queue is full")
+
+// A Priority orders conversations waiting for the same user
+type Priority int
+
+const (
+	// PriorityLow conversations wait behind everything else
+	PriorityLow Priority = iota
+	// PriorityNormal is the default for new conversations
+	PriorityNormal
+	// PriorityHigh conversations jump ahead of lower priority ones
+	// in the queue, but wait for the current conversation to finish
+	PriorityHigh
+	// PriorityUrgent conversations go to the front of the queue and
+	// start as soon as the current conversation is over. They never
+	// interrupt it, since a half finished script can't be paused
+	PriorityUrgent
+)
+
 type directMessage struct {
 	mutex *sync.Mutex
+	ctx   context.Context
 
 	currentConvo *conversation
-	convoQueue   chan *conversation
+	convoQueue   []*conversation
+	// Signals manageDM that the queue or current conversation changed
+	wake chan struct{}
+}
+
+func newDirectMessage(ctx context.Context) *directMessage {
+	return &directMessage{
+		mutex: &sync.Mutex{},
+		ctx:   ctx,
+
+		currentConvo: nil,
+		wake:         make(chan struct{}, 1),
+	}
 }
 
 func (dm *directMessage) manageDM() {
 	for {
-		// block until a conversation enters the queue
-		convo := <-dm.convoQueue
+		dm.mutex.Lock()
+		// Wait for a conversation to enter the queue, and for the
+		// current one to wrap up
+		if dm.queueEmpty() || dm.currentConvo != nil {
+			dm.mutex.Unlock()
+			select {
+			case <-dm.wake:
+				continue
+			case <-dm.ctx.Done():
+				return
+			}
+		}
 
 		// Mark it the current conversation
-		dm.mutex.Lock()
+		convo := dm.convoQueue[0]
+		dm.convoQueue = dm.convoQueue[1:]
 		dm.currentConvo = convo
+		convo.start(dm.ctx)
 		dm.mutex.Unlock()
 
 		// Walk through the script
-		convo.script(convo.msngr)
-		dm.mutex.Lock()
-		dm.currentConvo = nil
-		dm.mutex.Unlock()
+		dm.run(convo)
+	}
+}
+
+// run walks through the script of an already started conversation
+// and frees up the DM for the next one
+func (dm *directMessage) run(convo *conversation) {
+	convo.script(convo.msngr.ctx, convo.msngr)
+	convo.cancel()
+
+	dm.mutex.Lock()
+	dm.currentConvo = nil
+	dm.signal()
+	dm.mutex.Unlock()
+}
+
+// LOCK BEFORE YOU USE THIS
+func (dm *directMessage) signal() {
+	select {
+	case dm.wake <- struct{}{}:
+	default:
 	}
 }
 
@@ -38,24 +123,114 @@ func (dm *directMessage) queueEmpty() bool {
 	return len(dm.convoQueue) == 0
 }
 
+// LOCK BEFORE YOU USE THIS
+// enqueue applies the policy and returns the number of conversations
+// that are ahead of the new one
+func (dm *directMessage) enqueue(convo *conversation, policy QueuePolicy) (int, error) {
+	busy := 0
+	if dm.currentConvo != nil && !convo.handoff {
+		busy = 1
+	}
+
+	if policy == QueueMerge && convo.key != "" {
+		for i, queued := range dm.convoQueue {
+			if queued.key == convo.key {
+				return i + busy, nil
+			}
+		}
+	}
+
+	if len(dm.convoQueue) >= convoQueueSize {
+		if policy != QueueDropOldest {
+			return 0, ErrQueueFull
+		}
+		if !dm.dropOldest(convo.priority) {
+			return 0, ErrQueueFull
+		}
+	}
+
+	// Stay behind everything of the same or higher priority
+	i := len(dm.convoQueue)
+	for i > 0 && dm.convoQueue[i-1].priority < convo.priority {
+		i--
+	}
+	dm.convoQueue = append(dm.convoQueue, nil)
+	copy(dm.convoQueue[i+1:], dm.convoQueue[i:])
+	dm.convoQueue[i] = convo
+
+	dm.signal()
+
+	return i + busy, nil
+}
+
+// LOCK BEFORE YOU USE THIS
+// dropOldest removes the oldest of the lowest priority conversations,
+// as long as it is not more important than the one replacing it
+func (dm *directMessage) dropOldest(priority Priority) bool {
+	oldest := 0
+	for i, queued := range dm.convoQueue {
+		if queued.priority < dm.convoQueue[oldest].priority {
+			oldest = i
+		}
+	}
+
+	if dm.convoQueue[oldest].priority > priority {
+		return false
+	}
+
+	dm.convoQueue = append(dm.convoQueue[:oldest], dm.convoQueue[oldest+1:]...)
+	return true
+}
+
 type conversation struct {
 	msngr *Messenger
+	// Identifies duplicate conversations for QueueMerge, empty if
+	// the conversation should never be merged
+	key      string
+	priority Priority
+	// Queued by the current conversation as it wraps up, so it doesn't
+	// have to wait for it
+	handoff bool
 
 	script ConvoAction
+	// Cancels the context handed to the script, only set once the
+	// conversation has started
+	cancel context.CancelFunc
+}
+
+func (convo *conversation) start(ctx context.Context) {
+	convo.msngr.ctx, convo.cancel = context.WithCancel(ctx)
 }
 
 // ConvoAction describes the function signature needed to act
-// as a conversation entry point
-type ConvoAction func(*Messenger) error
+// as a conversation entry point. The context is cancelled when the
+// user backs out of the conversation, an admin kills it or the
+// SlackBot shuts down - the script should return soon after
+type ConvoAction func(context.Context, *Messenger) error
+
+func isCancelPhrase(text string) bool {
+	text = strings.Trim(strings.ToLower(strings.TrimSpace(text)), ".!")
+	for _, phrase := range CancelPhrases {
+		if text == phrase {
+			return true
+		}
+	}
+	return false
+}
 
 // A ConvoTopic is a possible topic of conversation to be registered
 // by a plugin
 type ConvoTopic struct {
-	// Unique ID for the topic - only alphanumeric
+	// Unique ID for the topic within its plugin - only alphanumeric.
+	// Topics can be started directly as "pluginid.topicid", or just
+	// "topicid" if no other plugin uses the same ID
 	ID string
 	// Human friendly name for the conversation topic - this what the
 	// user will see
 	Label string
+	// Extra words and phrases users might use when asking about the
+	// topic. Matched loosely against DMs, along with ID and Label
+	Keywords []string
 
 	// The entry point for the conversation.
 	// All action functions must only access global datastores in a threadsafe fasion
@@ -63,62 +238,255 @@ type ConvoTopic struct {
 
 	// Standin - implement later
 	Permissions *Permissions
+
+	key string
 }
 
-func (sb *SlackBot) smalltalk(msngr *Messenger) error {
+// Key returns the namespaced "pluginid.topicid" of the topic. It is
+// only set once the plugin has been added to a SlackBot
+func (topic *ConvoTopic) Key() string {
+	return topic.key
+}
+
+// smalltalk is the conversation started by a user DMing the SlackBot.
+// It tries to figure out the topic from the opening message before
+// falling back to the list of all topics
+func (sb *SlackBot) smalltalk(ctx context.Context, msngr *Messenger, opener string) error {
+	user := strings.TrimPrefix(msngr.ChannelName(), "@")
+	matches := sb.matchTopics(user, opener)
+	if confident(matches) {
+		return sb.startTopic(msngr, matches[0].topic)
+	}
+
+	if len(matches) > 0 {
+		topic, err := sb.didYouMean(ctx, msngr, matches)
+		if topic != nil || err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
 	msg := msngr.NewMessage(helpText)
-	msg.AddDropdown("Topics", sb.SortedConvoTopics()).AddButton("Cancel").Send()
+	topics := sb.allowedConvoTopics(user)
+	msg.AddDropdown("Topics", topics).AddButton("Cancel").Send()
 	cont, rsp := msngr.AwaitResponse()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if !cont {
 		msngr.NewMessage("We can finish this conversation some other time!").Send()
 		return nil
 	}
-	// TODO(nussey) Match against keywords and do fuzzy find
 
 	if rsp == "Cancel" {
 		return msngr.UpdateLastMessage("No problem! Let me know if I can help you later.", ColorDanger)
 	}
 
-	var topic *ConvoTopic
-	var ok bool
-	if topic, ok = sb.topics[rsp]; !ok {
-		msngr.UpdateLastMessage("I'm sorry, I am not sure what you mean by that :disappointed:", ColorWarning)
-		return nil
+	topic := sb.topicByLabel(rsp)
+	if topic == nil {
+		// Typed out instead of picked from the dropdown
+		if matches = sb.matchTopics(user, rsp); len(matches) == 0 {
+			msngr.UpdateLastMessage("I'm sorry, I am not sure what you mean by that :disappointed:", ColorWarning)
+			return nil
+		}
+		topic = matches[0].topic
 	}
-	sb.gm.NewConversation(msngr.ChannelName(), topic.Action)
-	msngr.UpdateLastMessage(rsp, ColorGood)
-	return nil
+
+	msngr.UpdateLastMessage(topic.Label, ColorGood)
+	return sb.startTopic(msngr, topic)
+}
+
+// didYouMean asks the user to pick between close topic matches. The
+// topic is nil if the user wants something else or walks away
+func (sb *SlackBot) didYouMean(ctx context.Context, msngr *Messenger, matches []topicMatch) (*ConvoTopic, error) {
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+
+	msg := msngr.NewMessage("I'm not quite sure what you're after. Did you mean...")
+	for _, match := range matches {
+		msg.AddButton(match.topic.Label)
+	}
+	msg.AddButton("Something else").Send()
+
+	cont, rsp := msngr.AwaitResponse()
+	if !cont {
+		return nil, nil
+	}
+
+	for _, match := range matches {
+		if rsp == match.topic.Label {
+			msngr.UpdateLastMessage(rsp, ColorGood)
+			return match.topic, sb.startTopic(msngr, match.topic)
+		}
+	}
+
+	msngr.UpdateLastMessage("Something else", ColorWarning)
+	return nil, nil
+}
+
+func (sb *SlackBot) startTopic(msngr *Messenger, topic *ConvoTopic) error {
+	event := &Event{
+		Kind:  EventConversation,
+		User:  strings.TrimPrefix(msngr.ChannelName(), "@"),
+		Text:  topic.Label,
+		Topic: topic,
+	}
+	err := sb.handle(event, func(event *Event) error {
+		convo := &conversation{
+			key:      topic.key,
+			priority: PriorityNormal,
+			handoff:  true,
+			script:   topic.Action,
+		}
+		return sb.gm.queueConversation(event.User, convo, "")
+	})
+	if reason := denial(err); reason != "" {
+		_, err = msngr.NewMessage(reason).Send()
+		return err
+	}
+	if err != nil {
+		_, err = msngr.NewMessage("I'm a little swamped right now, try again later!").Send()
+	}
+	return err
 }
 
 func (sb *SlackBot) dispatchConversation(ev *slack.MessageEvent) error {
-	user := sb.ims[ev.Channel].Name
+	im, ok := sb.imUser(ev.Channel)
+	if !ok {
+		return fmt.Errorf("DM from unknown channel %s", ev.Channel)
+	}
+	user := im.Name
 
-	dm := sb.gm.dms[user]
+	dm, ok := sb.gm.dm(user)
+	if !ok {
+		return fmt.Errorf("no direct message channel for user %s", user)
+	}
+
+	// Replies to a conversation go through the middleware too, so
+	// they are audited and rate limited like everything else
+	event := &Event{
+		Kind: EventMessage,
+		User: user,
+		Text: ev.Text,
+		Bot:  ev.BotID != "" || ev.SubType == "bot_message",
+	}
+	err := sb.handle(event, func(event *Event) error {
+		dm.mutex.Lock()
+		if dm.currentConvo != nil {
+			if isCancelPhrase(event.Text) {
+				dm.currentConvo.cancel()
+				dm.mutex.Unlock()
+				_, err := sb.gm.scope("@" + user).NewMessage("Okay, let's drop it.").Send()
+				return err
+			}
+			dm.currentConvo.msngr.respond(&Reply{Text: event.Text, User: user})
+			dm.mutex.Unlock()
+			return nil
+		}
+		dm.mutex.Unlock()
+
+		opener := event.Text
+		return sb.gm.NewConversation(user, func(ctx context.Context, msngr *Messenger) error {
+			return sb.smalltalk(ctx, msngr, opener)
+		})
+	})
+	if reason := denial(err); reason != "" {
+		_, err = sb.gm.scope("@" + user).NewMessage(reason).Send()
+	}
+	return err
+}
+
+// NewConversation starts a new conversation with a user. If the user
+// is already having a conversation with the slackbot, this gets added
+// to the queue and the user is told how many conversations are waiting.
+// It never blocks - a full queue is handled by the QueuePolicy of the
+// SlackBot
+func (gm *GlobalMessenger) NewConversation(user string, script ConvoAction) error {
+	return gm.newConversation(user, "", PriorityNormal, script)
+}
+
+// NewPriorityConversation is NewConversation with a Priority. Use it
+// for things that shouldn't wait behind the rest, like safety alerts
+func (gm *GlobalMessenger) NewPriorityConversation(user string, priority Priority, script ConvoAction) error {
+	return gm.newConversation(user, "", priority, script)
+}
+
+// NewTopicConversation starts a conversation about a registered topic.
+// Unlike NewConversation, duplicate topics can be merged by QueueMerge
+func (gm *GlobalMessenger) NewTopicConversation(user string, topic *ConvoTopic) error {
+	return gm.newConversation(user, topic.key, PriorityNormal, topic.Action)
+}
+
+// CancelConversation kills the conversation a user is currently having
+// with the SlackBot. Queued conversations are left alone. Returns false
+// if the user was not in a conversation
+func (gm *GlobalMessenger) CancelConversation(user string) bool {
+	user = strings.TrimPrefix(user, "@")
+	if user == "" {
+		return false
+	}
+
+	dm, ok := gm.dm(user)
+	if !ok {
+		return false
+	}
 
 	dm.mutex.Lock()
 	defer dm.mutex.Unlock()
-	if dm.currentConvo != nil {
-		dm.currentConvo.msngr.respond(ev.Text, false)
-		return nil
+
+	if dm.currentConvo == nil {
+		return false
 	}
 
-	sb.gm.NewConversation(user, sb.smalltalk)
+	dm.currentConvo.cancel()
+	return true
+}
 
-	return nil
+func (gm *GlobalMessenger) newConversation(user string, key string, priority Priority, script ConvoAction) error {
+	convo := &conversation{
+		key:      key,
+		priority: priority,
+		script:   script,
+	}
+
+	return gm.queueConversation(user, convo, "")
 }
 
-// Start a new conversation with a user
-// If the user is alreay having a conversation with the slackbot,
-// this gets added to the queue
-func (gm *GlobalMessenger) NewConversation(user string, script ConvoAction) {
-	if string(user[0]) == "@" {
-		user = user[1:]
+// queueConversation scopes the conversation to the user's DM and queues
+// it. trigger is the ID of the interaction that started it, if any, so
+// the script can open a dialog right away
+func (gm *GlobalMessenger) queueConversation(user string, convo *conversation, trigger string) error {
+	user = strings.TrimPrefix(user, "@")
+	if user == "" {
+		return fmt.Errorf("no user to have a conversation with")
 	}
-	convo := &conversation{
-		msngr:  gm.scope("@" + user),
-		script: script,
+
+	dm, ok := gm.dm(user)
+	if !ok {
+		return fmt.Errorf("no direct message channel for user %s", user)
 	}
 
-	gm.dms[user].convoQueue <- convo
+	convo.msngr = gm.scope("@" + user)
+	convo.msngr.trigger = trigger
 
+	dm.mutex.Lock()
+	waiting, err := dm.enqueue(convo, gm.queuePolicy)
+	dm.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if waiting > 0 {
+		text := fmt.Sprintf("I have %d conversations waiting with you, I'll get to this one as soon as we are done", waiting)
+		if waiting == 1 {
+			text = "I have another conversation waiting with you, I'll get to it as soon as we are done"
+		}
+		_, err = gm.scope("@" + user).NewMessage(text).Send()
+		return err
+	}
+
+	return nil
 }
@@ -1,6 +1,8 @@
 package gtsr
 
 import (
+	"fmt"
+
 	"github.com/robfig/cron"
 )
 
@@ -32,8 +34,12 @@ func (sb *SlackBot) initCron() {
 	c := cron.New()
 
 	for _, job := range sb.crons {
+		job := job
 		c.AddFunc(job.Spec, func() {
-			job.Action(sb.gm)
+			err := job.Action(sb.gm)
+			if err != nil {
+				sb.cronFailed(job, err)
+			}
 		})
 	}
 
@@ -41,3 +47,17 @@ func (sb *SlackBot) initCron() {
 
 	sb.scheduler = c
 }
+
+// cronFailed prints the error of a cron job and posts it to the admin
+// channel, since there is nobody else to tell
+func (sb *SlackBot) cronFailed(job *CronJob, err error) {
+	text := fmt.Sprintf("Cron job %s (%s) failed: %s", job.Name, job.ID, err)
+	fmt.Println(text)
+
+	if admin := sb.AdminChannel(); admin != "" {
+		_, err = sb.gm.scope(admin).NewMessage(text).Send()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package gtsr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// Prefix of button values that start a topic instead of answering
+// the message they are attached to
+const topicLinkPrefix = "topic:"
+
+func (sb *SlackBot) topicByLabel(label string) *ConvoTopic {
+	for _, topic := range sb.topics {
+		if topic.Label == label {
+			return topic
+		}
+	}
+	return nil
+}
+
+// findTopic looks up a topic by its namespaced key ("helptext.faq"), or
+// by its bare ID ("faq") as long as only one plugin uses that ID
+func (sb *SlackBot) findTopic(ref string) *ConvoTopic {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	if topic, ok := sb.topics[ref]; ok {
+		return topic
+	}
+
+	var found *ConvoTopic
+	for _, topic := range sb.topics {
+		if strings.ToLower(topic.ID) == ref {
+			if found != nil {
+				return nil
+			}
+			found = topic
+		}
+	}
+	return found
+}
+
+// StartTopic starts a conversation with the user about the topic with
+// the given key ("pluginid.topicid") or unambiguous topic ID
+func (sb *SlackBot) StartTopic(user string, ref string) error {
+	return sb.startTopicTriggered(user, ref, "")
+}
+
+// startTopicTriggered is StartTopic for topics started by a button or
+// slash command, whose trigger ID lets the script open a dialog
+func (sb *SlackBot) startTopicTriggered(user string, ref string, trigger string) error {
+	topic := sb.findTopic(ref)
+	if topic == nil {
+		return fmt.Errorf("no topic %s", ref)
+	}
+
+	event := &Event{
+		Kind:  EventConversation,
+		User:  user,
+		Text:  ref,
+		Topic: topic,
+	}
+	return sb.handle(event, func(event *Event) error {
+		convo := &conversation{
+			key:      topic.key,
+			priority: PriorityNormal,
+			script:   topic.Action,
+		}
+		return sb.gm.queueConversation(event.User, convo, trigger)
+	})
+}
+
+// mentionDeepLink starts a topic when someone mentions the SlackBot in
+// a channel with a topic ID, as in "@clippy faq". Mention handlers of
+// the plugins come first, so "@clippy status" is answered in the
+// channel even though there is a status topic. Returns true if the
+// message was handled
+func (sb *SlackBot) mentionDeepLink(ev *slack.MessageEvent) bool {
+	mention := "<@" + sb.rtm.GetInfo().User.ID + ">"
+	if !strings.HasPrefix(ev.Text, mention) {
+		return false
+	}
+	if sb.mentionHandled(ev.Text, sb.channelName(ev.Channel), mention) {
+		return false
+	}
+
+	user, ok := sb.user(ev.User)
+	if !ok {
+		return false
+	}
+
+	ref := strings.TrimLeft(strings.TrimPrefix(ev.Text, mention), ": ")
+	err := sb.StartTopic(user.Name, ref)
+	if reason := denial(err); reason != "" {
+		_, err = sb.gm.scope(sb.channelName(ev.Channel)).NewMessage(reason).Send()
+		if err != nil {
+			fmt.Println(err)
+		}
+		return true
+	}
+	if err != nil {
+		return false
+	}
+
+	sb.api.AddReaction("speech_balloon", slack.ItemRef{
+		Channel:   ev.Channel,
+		Timestamp: ev.Timestamp,
+	})
+	return true
+}
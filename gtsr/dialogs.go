@@ -0,0 +1,380 @@
+package gtsr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const dialogOpenURL = "https://slack.com/api/dialog.open"
+
+// ErrNoTrigger is returned by OpenDialog when the Messenger hasn't
+// been clicked on, or the click is too old to open a dialog
+var ErrNoTrigger = fmt.Errorf("dialogs can only be opened right after a button click or slash command")
+
+// A DialogElement is a single input in a Dialog. Use the Dialog*
+// constructors rather than building these by hand
+type DialogElement struct {
+	// Key of the value in the submission
+	Name  string
+	Label string
+	// Greyed out text shown while the input is empty
+	Placeholder string
+	// Help text shown under the input
+	Hint     string
+	Optional bool
+	// Extra server side validation. The error message is shown under
+	// the input and the dialog stays open
+	Validate func(string) error
+
+	kind       string
+	subtype    string
+	options    []string
+	dataSource string
+}
+
+// A Dialog is a modal form that pops up over Slack
+type Dialog struct {
+	Title       string
+	SubmitLabel string
+	Elements    []*DialogElement
+}
+
+// DialogText creates a single line text input
+func DialogText(name, label string) *DialogElement {
+	return &DialogElement{Name: name, Label: label, kind: "text"}
+}
+
+// DialogTextArea creates a multi line text input
+func DialogTextArea(name, label string) *DialogElement {
+	return &DialogElement{Name: name, Label: label, kind: "textarea"}
+}
+
+// DialogSelect creates a dropdown of fixed options
+func DialogSelect(name, label string, options []string) *DialogElement {
+	return &DialogElement{Name: name, Label: label, kind: "select", options: options}
+}
+
+// DialogUserSelect creates a dropdown of workspace users, submitting
+// the user ID
+func DialogUserSelect(name, label string) *DialogElement {
+	return &DialogElement{Name: name, Label: label, kind: "select", dataSource: "users"}
+}
+
+// DialogChannelSelect creates a dropdown of channels, submitting the
+// channel ID
+func DialogChannelSelect(name, label string) *DialogElement {
+	return &DialogElement{Name: name, Label: label, kind: "select", dataSource: "channels"}
+}
+
+// DialogDate creates a text input that only accepts dates, in any of
+// the formats AskDate understands. The submitted value is normalized
+// to YYYY-MM-DD
+func DialogDate(name, label string) *DialogElement {
+	return &DialogElement{
+		Name:        name,
+		Label:       label,
+		Placeholder: "YYYY-MM-DD",
+		Hint:        "You can also write things like \"tomorrow\" or \"friday\"",
+		kind:        "text",
+		subtype:     "date",
+	}
+}
+
+// pendingDialog is a dialog waiting for the user to submit it
+type pendingDialog struct {
+	dialog  *Dialog
+	expires time.Time
+	result  chan map[string]string
+}
+
+type dialogElementJSON struct {
+	Type        string             `json:"type"`
+	Name        string             `json:"name"`
+	Label       string             `json:"label"`
+	Placeholder string             `json:"placeholder,omitempty"`
+	Hint        string             `json:"hint,omitempty"`
+	Optional    bool               `json:"optional,omitempty"`
+	DataSource  string             `json:"data_source,omitempty"`
+	Options     []dialogOptionJSON `json:"options,omitempty"`
+}
+
+type dialogOptionJSON struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+type dialogJSON struct {
+	CallbackID     string              `json:"callback_id"`
+	Title          string              `json:"title"`
+	SubmitLabel    string              `json:"submit_label,omitempty"`
+	NotifyOnCancel bool                `json:"notify_on_cancel"`
+	Elements       []dialogElementJSON `json:"elements"`
+}
+
+// A dialogError is shown under the input it names
+type dialogError struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// OpenDialog pops the dialog up for the user and blocks until they
+// submit it, returning the values keyed by element Name. It needs a
+// fresh trigger, so call it right after the user clicks a button or
+// starts the conversation with a slash command. ErrCancelled is
+// returned if they close the dialog instead
+func (msngr *Messenger) OpenDialog(dialog *Dialog) (map[string]string, error) {
+	msngr.gm.listener.mutex.Lock()
+	trigger := msngr.trigger
+	msngr.trigger = ""
+	msngr.gm.listener.mutex.Unlock()
+
+	if trigger == "" {
+		return nil, ErrNoTrigger
+	}
+
+	pending := &pendingDialog{
+		dialog:  dialog,
+		expires: time.Now().Add(DefaultTimeout),
+		result:  make(chan map[string]string, 1),
+	}
+	callbackID := msngr.gm.listener.registerDialog(pending)
+	defer msngr.gm.listener.unregisterDialog(callbackID)
+
+	err := msngr.gm.openDialog(trigger, dialog.toJSON(callbackID))
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(DefaultTimeout)
+	defer timer.Stop()
+
+	select {
+	case values, ok := <-pending.result:
+		if !ok {
+			return nil, ErrCancelled
+		}
+		return values, nil
+	case <-msngr.ctx.Done():
+		return nil, ErrCancelled
+	case <-timer.C:
+		return nil, ErrTimeout
+	}
+}
+
+// OpenDialogInto is OpenDialog that stores the values in the string
+// fields of dest, a pointer to a struct, matched by element Name
+func (msngr *Messenger) OpenDialogInto(dialog *Dialog, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dialog destination must be a pointer to a struct, got %T", dest)
+	}
+	for _, el := range dialog.Elements {
+		f := v.Elem().FieldByName(el.Name)
+		if !f.IsValid() || !f.CanSet() || f.Kind() != reflect.String {
+			return fmt.Errorf("dialog element %s has no exported string field in %T", el.Name, dest)
+		}
+	}
+
+	values, err := msngr.OpenDialog(dialog)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range values {
+		if f := v.Elem().FieldByName(name); f.IsValid() {
+			f.SetString(value)
+		}
+	}
+	return nil
+}
+
+func (gm *GlobalMessenger) openDialog(trigger string, dialog *dialogJSON) error {
+	raw, err := json.Marshal(dialog)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.PostForm(dialogOpenURL, url.Values{
+		"token":      {gm.apikey},
+		"trigger_id": {trigger},
+		"dialog":     {string(raw)},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("dialog.open failed: %s", result.Error)
+	}
+	return nil
+}
+
+func (dialog *Dialog) toJSON(callbackID string) *dialogJSON {
+	out := &dialogJSON{
+		CallbackID:     callbackID,
+		Title:          dialog.Title,
+		SubmitLabel:    dialog.SubmitLabel,
+		NotifyOnCancel: true,
+	}
+
+	for _, el := range dialog.Elements {
+		jel := dialogElementJSON{
+			Type:        el.kind,
+			Name:        el.Name,
+			Label:       el.Label,
+			Placeholder: el.Placeholder,
+			Hint:        el.Hint,
+			Optional:    el.Optional,
+			DataSource:  el.dataSource,
+		}
+		for _, opt := range el.options {
+			jel.Options = append(jel.Options, dialogOptionJSON{Label: opt, Value: opt})
+		}
+		out.Elements = append(out.Elements, jel)
+	}
+
+	return out
+}
+
+// validate checks a submission, normalizing values in place. Any
+// errors are shown in the dialog, which stays open
+func (dialog *Dialog) validate(values map[string]string) []dialogError {
+	var errs []dialogError
+	for _, el := range dialog.Elements {
+		value := strings.TrimSpace(values[el.Name])
+		if value == "" {
+			continue
+		}
+
+		if el.subtype == "date" {
+			date, err := parseDate(value, time.Now())
+			if err != nil {
+				errs = append(errs, dialogError{Name: el.Name, Error: err.Error()})
+				continue
+			}
+			value = date.Format("2006-01-02")
+			values[el.Name] = value
+		}
+
+		if el.Validate != nil {
+			if err := el.Validate(value); err != nil {
+				errs = append(errs, dialogError{Name: el.Name, Error: err.Error()})
+			}
+		}
+	}
+	return errs
+}
+
+func (l *callbackListener) registerDialog(pending *pendingDialog) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	id := randStringRunes(callbackIDLen)
+	for _, ok := l.dialogs[id]; ok; _, ok = l.dialogs[id] {
+		id = randStringRunes(callbackIDLen)
+	}
+
+	l.dialogs[id] = pending
+	return id
+}
+
+func (l *callbackListener) unregisterDialog(id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.dialogs, id)
+}
+
+// A dialogCallback is sent by Slack when a dialog is submitted or
+// closed
+type dialogCallback struct {
+	Type       string            `json:"type"`
+	CallbackID string            `json:"callback_id"`
+	Submission map[string]string `json:"submission"`
+	User       struct {
+		Name string `json:"name"`
+	} `json:"user"`
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+}
+
+// handleDialog validates a submission and hands it to the waiting
+// script. Validation errors are sent back so Slack shows them inline.
+// Submissions go through the middleware like clicks, cancellations
+// always get through so the script isn't left waiting
+func (sb *SlackBot) handleDialog(w http.ResponseWriter, callback *dialogCallback) {
+	sb.gm.listener.mutex.Lock()
+	pending := sb.gm.listener.dialogs[callback.CallbackID]
+	if pending != nil && callback.Type == "dialog_cancellation" {
+		delete(sb.gm.listener.dialogs, callback.CallbackID)
+	}
+	sb.gm.listener.mutex.Unlock()
+
+	if pending == nil {
+		fmt.Println("unregistered dialog!")
+		ack(w, nil)
+		return
+	}
+
+	if callback.Type == "dialog_cancellation" {
+		close(pending.result)
+		ack(w, nil)
+		return
+	}
+
+	errs := pending.dialog.validate(callback.Submission)
+	if len(errs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]dialogError{"errors": errs})
+		return
+	}
+
+	event := &Event{
+		Kind: EventInteraction,
+		User: callback.User.Name,
+		Text: pending.dialog.Title,
+	}
+	if callback.Channel.ID != "" && callback.Channel.ID[0] != dm {
+		event.Channel = callback.Channel.Name
+	}
+
+	err := sb.handle(event, func(*Event) error {
+		sb.gm.listener.mutex.Lock()
+		_, ok := sb.gm.listener.dialogs[callback.CallbackID]
+		delete(sb.gm.listener.dialogs, callback.CallbackID)
+		sb.gm.listener.mutex.Unlock()
+
+		// Only the first of a double submit gets through
+		if ok {
+			pending.result <- callback.Submission
+		}
+		return nil
+	})
+	if reason := denial(err); reason != "" && len(pending.dialog.Elements) > 0 {
+		// Dialogs can only show errors next to an element
+		errs := []dialogError{{Name: pending.dialog.Elements[0].Name, Error: reason}}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]dialogError{"errors": errs})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	ack(w, nil)
+}
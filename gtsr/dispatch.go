@@ -0,0 +1,226 @@
+package gtsr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of channel messages handled at once, unless changed with
+	// SetWorkers
+	defaultWorkers = 8
+	// How long a plugin gets to handle a message, unless its
+	// PluginConfig says otherwise
+	defaultPluginTimeout = 10 * time.Second
+)
+
+// DispatchStats is a snapshot of the channel message dispatcher
+type DispatchStats struct {
+	// Size of the worker pool
+	Workers int
+	// Workers currently handling a message
+	Busy int
+	// Messages waiting for a worker
+	Queued int
+	// Most messages ever waiting at once
+	MaxQueued int
+	// Channels with messages waiting or being handled
+	Channels int
+	// Messages handled so far
+	Dispatched uint64
+	// Plugins that took longer than their timeout
+	Timeouts uint64
+}
+
+// A dispatcher handles channel messages on a bounded pool of workers.
+// Every channel has its own lane, and a lane is only ever worked on by
+// one worker at a time so messages in a channel are handled in order
+type dispatcher struct {
+	mutex *sync.Mutex
+	cond  *sync.Cond
+
+	// Pending jobs by channel ID. A lane exists while it has jobs
+	// waiting or a worker is on it
+	lanes map[string][]func()
+	// Lanes waiting for a worker, oldest first
+	ready   []string
+	stopped bool
+
+	stats DispatchStats
+}
+
+func newDispatcher(workers int) *dispatcher {
+	mutex := &sync.Mutex{}
+	return &dispatcher{
+		mutex: mutex,
+		cond:  sync.NewCond(mutex),
+		lanes: make(map[string][]func()),
+		stats: DispatchStats{Workers: workers},
+	}
+}
+
+// start runs the workers until ctx is done
+func (d *dispatcher) start(ctx context.Context) {
+	for i := 0; i < d.stats.Workers; i++ {
+		go d.work()
+	}
+
+	go func() {
+		<-ctx.Done()
+		d.mutex.Lock()
+		d.stopped = true
+		d.mutex.Unlock()
+		d.cond.Broadcast()
+	}()
+}
+
+// submit queues job behind everything else waiting in the channel
+func (d *dispatcher) submit(channel string, job func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	lane, ok := d.lanes[channel]
+	d.lanes[channel] = append(lane, job)
+	if !ok {
+		d.ready = append(d.ready, channel)
+		d.cond.Signal()
+	}
+
+	d.stats.Queued++
+	if d.stats.Queued > d.stats.MaxQueued {
+		d.stats.MaxQueued = d.stats.Queued
+	}
+}
+
+func (d *dispatcher) work() {
+	for {
+		d.mutex.Lock()
+		for len(d.ready) == 0 && !d.stopped {
+			d.cond.Wait()
+		}
+		if d.stopped {
+			d.mutex.Unlock()
+			return
+		}
+
+		channel := d.ready[0]
+		d.ready = d.ready[1:]
+		d.stats.Busy++
+		d.mutex.Unlock()
+
+		d.drain(channel)
+
+		d.mutex.Lock()
+		d.stats.Busy--
+		d.mutex.Unlock()
+	}
+}
+
+// drain runs the jobs of a lane until it is empty
+func (d *dispatcher) drain(channel string) {
+	for {
+		d.mutex.Lock()
+		lane := d.lanes[channel]
+		if len(lane) == 0 || d.stopped {
+			delete(d.lanes, channel)
+			d.mutex.Unlock()
+			return
+		}
+		job := lane[0]
+		d.lanes[channel] = lane[1:]
+		d.stats.Queued--
+		d.mutex.Unlock()
+
+		job()
+
+		d.mutex.Lock()
+		d.stats.Dispatched++
+		d.mutex.Unlock()
+	}
+}
+
+func (d *dispatcher) timedOut() {
+	d.mutex.Lock()
+	d.stats.Timeouts++
+	d.mutex.Unlock()
+}
+
+func (d *dispatcher) snapshot() DispatchStats {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats := d.stats
+	stats.Channels = len(d.lanes)
+	return stats
+}
+
+// SetWorkers sets how many channel messages are handled at once. The
+// default is 8. Call this before ServeSlack()
+func (sb *SlackBot) SetWorkers(workers int) {
+	if sb.running {
+		panic("Set the number of workers before starting the Slack Bot")
+	}
+	if workers < 1 {
+		panic("The Slack Bot needs at least one worker")
+	}
+
+	sb.dispatcher = newDispatcher(workers)
+}
+
+// DispatchStats returns how busy the channel message dispatcher is
+func (sb *SlackBot) DispatchStats() DispatchStats {
+	return sb.dispatcher.snapshot()
+}
+
+// callPlugins hands msg to every plugin turned on in the channel at
+// once and waits for them to finish. Each plugin gets its own Messenger
+// so they can't step on each other's messages. A plugin that takes
+// longer than its timeout is logged and left to finish in the
+// background, so it can't hold up the channel
+func (sb *SlackBot) callPlugins(msg *IncomingMessage) {
+	wg := &sync.WaitGroup{}
+	channel := sb.channelName(msg.channel)
+
+	for _, lp := range sb.plugins {
+		if !sb.switches.enabled(lp.config.ID, channel) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(lp *loadedPlugin) {
+			defer wg.Done()
+
+			msngr := sb.gm.scope(channel)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+
+				// TODO(nussey): much better error handling
+				err := lp.plugin.ParseMessage(msg, msngr)
+				if err != nil {
+					fmt.Println(err)
+				}
+
+				sb.routeMessage(lp, msg, msngr)
+			}()
+
+			timeout := lp.config.Timeout
+			if timeout == 0 {
+				timeout = defaultPluginTimeout
+			}
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+
+			select {
+			case <-done:
+			case <-timer.C:
+				sb.dispatcher.timedOut()
+				fmt.Printf("plugin %s took longer than %s to handle a message\n", lp.config.ID, timeout)
+			}
+		}(lp)
+	}
+
+	wg.Wait()
+}
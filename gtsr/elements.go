@@ -0,0 +1,142 @@
+package gtsr
+
+import (
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// Slack won't accept a menu with more options than this
+const maxMenuOptions = 100
+
+// An OptionsProvider serves the options of an external dropdown as the
+// user types. query is whatever they have typed so far. At most 100
+// options are shown
+type OptionsProvider func(query string) []string
+
+// An OptionGroup is a titled set of options in a grouped dropdown
+type OptionGroup struct {
+	Label   string
+	Options []string
+}
+
+// AddUserPicker creates a dropdown of every user in the workspace. The
+// answer is the user ID of the person picked. The original message
+// pointer is returned to allow method chaining
+func (msg *OutgoingMessage) AddUserPicker(label string) *OutgoingMessage {
+	return msg.addDataSource(label, "users")
+}
+
+// AddChannelPicker creates a dropdown of every public channel. The
+// answer is the channel ID. The original message pointer is returned
+// to allow method chaining
+func (msg *OutgoingMessage) AddChannelPicker(label string) *OutgoingMessage {
+	return msg.addDataSource(label, "channels")
+}
+
+func (msg *OutgoingMessage) addDataSource(label string, source string) *OutgoingMessage {
+	msg.interactive = true
+
+	action := slack.AttachmentAction{
+		Name:       label,
+		Text:       label,
+		Type:       "select",
+		DataSource: source,
+	}
+	msg.actions = append(msg.actions, action)
+
+	return msg
+}
+
+// AddGroupedDropdown creates a dropdown with its options split under
+// headings. The answer is the option picked. The original message
+// pointer is returned to allow method chaining
+func (msg *OutgoingMessage) AddGroupedDropdown(label string, groups []OptionGroup) *OutgoingMessage {
+	msg.interactive = true
+
+	action := slack.AttachmentAction{
+		Name: label,
+		Text: label,
+		Type: "select",
+	}
+
+	for _, group := range groups {
+		agroup := slack.AttachmentActionOptionGroup{
+			Text: group.Label,
+		}
+		for _, opt := range group.Options {
+			id := randStringRunes(8)
+			msg.elements[id] = opt
+			agroup.Options = append(agroup.Options, slack.AttachmentActionOption{
+				Text:  opt,
+				Value: id,
+			})
+		}
+		action.OptionGroups = append(action.OptionGroups, agroup)
+	}
+
+	msg.actions = append(msg.actions, action)
+
+	return msg
+}
+
+// AddExternalDropdown creates a dropdown whose options are loaded from
+// provider as the user types, once they have typed minQuery characters.
+// Slack must have the options load URL of the app pointed at /options.
+// The original message pointer is returned to allow method chaining
+func (msg *OutgoingMessage) AddExternalDropdown(label string, minQuery int, provider OptionsProvider) *OutgoingMessage {
+	msg.interactive = true
+
+	if msg.providers == nil {
+		msg.providers = make(map[string]OptionsProvider)
+	}
+	msg.providers[label] = provider
+
+	action := slack.AttachmentAction{
+		Name:           label,
+		Text:           label,
+		Type:           "select",
+		DataSource:     "external",
+		MinQueryLength: minQuery,
+	}
+	msg.actions = append(msg.actions, action)
+
+	return msg
+}
+
+// options runs the provider of an external dropdown, trimming the
+// result down to what Slack accepts
+func (msg *OutgoingMessage) options(name string, query string) []slack.AttachmentActionOption {
+	provider, ok := msg.providers[name]
+	if !ok {
+		return nil
+	}
+
+	var options []slack.AttachmentActionOption
+	for _, opt := range provider(query) {
+		if len(options) == maxMenuOptions {
+			break
+		}
+		options = append(options, slack.AttachmentActionOption{
+			Text:  opt,
+			Value: opt,
+		})
+	}
+	return options
+}
+
+// staticOptions searches a fixed list of options, for dropdowns too
+// big to send to Slack in one go
+func staticOptions(all []string) OptionsProvider {
+	return func(query string) []string {
+		query = strings.ToLower(query)
+
+		var options []string
+		for _, opt := range all {
+			if strings.Contains(strings.ToLower(opt), query) {
+				options = append(options, opt)
+			}
+		}
+		return options
+	}
+}
@@ -0,0 +1,298 @@
+package gtsr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A Flow is a conversation described as a set of named states and the
+// transitions between them, instead of a hand written ConvoAction. The
+// framework sends each state's message, waits for an answer and follows
+// the matching transition until it reaches a state with none
+type Flow struct {
+	// Human friendly name, used as the title of the graph
+	Name string
+	// Name of the state the conversation starts in
+	Start  string
+	States []*FlowState
+}
+
+// A FlowState is a single step of a Flow
+type FlowState struct {
+	// Unique name of the state within the flow
+	Name string
+	// Message sent when entering the state, may be empty
+	Text string
+	// Optional hook run when entering the state, before Text is sent.
+	// Returning a non empty state name jumps straight there
+	Enter func(ctx context.Context, msngr *Messenger, run *FlowRun) (string, error)
+
+	// Buttons shown with Text, each leading to another state. Typing
+	// the label of a choice works too
+	Choices []FlowChoice
+	// Text answers matched in order. Named captures are stored in the
+	// Vars of the FlowRun
+	Patterns []FlowPattern
+	// State to go to for answers nothing else matched. If empty the
+	// user is asked again
+	Otherwise string
+
+	// How long to wait for an answer, DefaultTimeout if zero
+	Timeout time.Duration
+	// State to go to when the user doesn't answer in time. If empty
+	// the conversation ends
+	OnTimeout string
+}
+
+// A FlowChoice is a button transition
+type FlowChoice struct {
+	Label string
+	Next  string
+}
+
+// A FlowPattern is a text transition
+type FlowPattern struct {
+	Pattern *regexp.Regexp
+	Next    string
+}
+
+// A FlowRun is the progress of a user through a Flow. Save it to resume
+// the conversation later by handing it back to Run
+type FlowRun struct {
+	// Name of the current state
+	State string
+	// Named captures from matched patterns
+	Vars map[string]string
+	// Every state visited so far, in order
+	Path []string
+}
+
+// NewRun returns a FlowRun at the start of the flow
+func (flow *Flow) NewRun() *FlowRun {
+	return &FlowRun{
+		State: flow.Start,
+		Vars:  make(map[string]string),
+	}
+}
+
+// Validate checks that the start state and every transition point at
+// states that exist
+func (flow *Flow) Validate() error {
+	names := make(map[string]bool)
+	for _, state := range flow.States {
+		if names[state.Name] {
+			return fmt.Errorf("flow %s: duplicate state %s", flow.Name, state.Name)
+		}
+		names[state.Name] = true
+	}
+
+	if !names[flow.Start] {
+		return fmt.Errorf("flow %s: unknown start state %s", flow.Name, flow.Start)
+	}
+
+	for _, state := range flow.States {
+		for _, next := range state.targets() {
+			if !names[next] {
+				return fmt.Errorf("flow %s: state %s leads to unknown state %s", flow.Name, state.Name, next)
+			}
+		}
+	}
+	return nil
+}
+
+func (flow *Flow) state(name string) *FlowState {
+	for _, state := range flow.States {
+		if state.Name == name {
+			return state
+		}
+	}
+	return nil
+}
+
+// Step returns the state to move to from the current state of run given
+// the user's answer, storing any named captures in run. The second
+// return is false if nothing matched and the user should be asked again.
+// Step does no I/O, which makes flows easy to unit test
+func (flow *Flow) Step(run *FlowRun, input string) (string, bool) {
+	state := flow.state(run.State)
+	if state == nil {
+		return "", false
+	}
+
+	for _, choice := range state.Choices {
+		if strings.EqualFold(strings.TrimSpace(input), choice.Label) {
+			return choice.Next, true
+		}
+	}
+
+	for _, pattern := range state.Patterns {
+		match := pattern.Pattern.FindStringSubmatch(input)
+		if match == nil {
+			continue
+		}
+		for i, name := range pattern.Pattern.SubexpNames() {
+			if name != "" {
+				run.Vars[name] = match[i]
+			}
+		}
+		return pattern.Next, true
+	}
+
+	if state.Otherwise != "" {
+		return state.Otherwise, true
+	}
+
+	return "", false
+}
+
+// Action turns the flow into a ConvoAction that always starts fresh,
+// for use in a ConvoTopic or NewConversation
+func (flow *Flow) Action() ConvoAction {
+	return func(ctx context.Context, msngr *Messenger) error {
+		return flow.Run(ctx, msngr, flow.NewRun())
+	}
+}
+
+// Run walks the user through the flow from the current state of run,
+// updating run as it goes. A run saved from an earlier conversation
+// picks up where it left off, sending the current state again
+func (flow *Flow) Run(ctx context.Context, msngr *Messenger, run *FlowRun) error {
+	err := flow.Validate()
+	if err != nil {
+		return err
+	}
+
+	for {
+		state := flow.state(run.State)
+		if state == nil {
+			return fmt.Errorf("flow %s: no state %q", flow.Name, run.State)
+		}
+		run.Path = append(run.Path, state.Name)
+
+		if state.Enter != nil {
+			next, err := state.Enter(ctx, msngr, run)
+			if err != nil {
+				return err
+			}
+			if next != "" {
+				run.State = next
+				continue
+			}
+		}
+
+		if state.terminal() {
+			if state.Text != "" {
+				_, err = msngr.NewMessage(state.Text).Send()
+			}
+			return err
+		}
+
+		next, err := flow.await(ctx, msngr, run, state)
+		if err != nil || next == "" {
+			return err
+		}
+		run.State = next
+	}
+}
+
+// await sends the message of a state until it gets an answer with a
+// transition, returning the next state. An empty state means the
+// conversation is over
+func (flow *Flow) await(ctx context.Context, msngr *Messenger, run *FlowRun, state *FlowState) (string, error) {
+	timeout := state.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	text := state.Text
+	for {
+		msg := msngr.NewMessage(text)
+		for _, choice := range state.Choices {
+			msg.AddButton(choice.Label)
+		}
+		_, err := msg.Send()
+		if err != nil {
+			return "", err
+		}
+
+		cont, rsp := msngr.AwaitRespondseTimeout(timeout)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if !cont {
+			msngr.UpdateLastMessage("No answer", ColorDanger)
+			return state.OnTimeout, nil
+		}
+
+		next, ok := flow.Step(run, rsp)
+		if ok {
+			if len(state.Choices) > 0 {
+				msngr.UpdateLastMessage(rsp, ColorGood)
+			}
+			return next, nil
+		}
+
+		text = "Sorry, I didn't get that. " + state.Text
+	}
+}
+
+func (state *FlowState) terminal() bool {
+	return len(state.Choices) == 0 && len(state.Patterns) == 0 && state.Otherwise == ""
+}
+
+func (state *FlowState) targets() []string {
+	var targets []string
+	for _, choice := range state.Choices {
+		targets = append(targets, choice.Next)
+	}
+	for _, pattern := range state.Patterns {
+		targets = append(targets, pattern.Next)
+	}
+	if state.Otherwise != "" {
+		targets = append(targets, state.Otherwise)
+	}
+	if state.OnTimeout != "" {
+		targets = append(targets, state.OnTimeout)
+	}
+	return targets
+}
+
+// DOT renders the flow as a Graphviz digraph, so it can be reviewed
+// with `dot -Tpng`. Button transitions are solid, text patterns are
+// dashed and timeouts are dotted
+func (flow *Flow) DOT() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "digraph %q {\n", flow.Name)
+	fmt.Fprintf(&buf, "\tlabel=%q;\n", flow.Name)
+	fmt.Fprintf(&buf, "\t__start [shape=point];\n")
+	fmt.Fprintf(&buf, "\t__start -> %q;\n", flow.Start)
+
+	for _, state := range flow.States {
+		shape := "box"
+		if state.terminal() {
+			shape = "doublecircle"
+		}
+		fmt.Fprintf(&buf, "\t%q [shape=%s];\n", state.Name, shape)
+
+		for _, choice := range state.Choices {
+			fmt.Fprintf(&buf, "\t%q -> %q [label=%q];\n", state.Name, choice.Next, choice.Label)
+		}
+		for _, pattern := range state.Patterns {
+			fmt.Fprintf(&buf, "\t%q -> %q [label=%q, style=dashed];\n", state.Name, pattern.Next, "/"+pattern.Pattern.String()+"/")
+		}
+		if state.Otherwise != "" {
+			fmt.Fprintf(&buf, "\t%q -> %q [label=\"otherwise\", style=dashed];\n", state.Name, state.Otherwise)
+		}
+		if state.OnTimeout != "" {
+			fmt.Fprintf(&buf, "\t%q -> %q [label=\"timeout\", style=dotted];\n", state.Name, state.OnTimeout)
+		}
+	}
+
+	buf.WriteString("}\n")
+	return buf.String()
+}
@@ -0,0 +1,132 @@
+package gtsr
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func testFlow() *Flow {
+	return &Flow{
+		Name:  "order",
+		Start: "size",
+		States: []*FlowState{{
+			Name: "size",
+			Text: "What size?",
+			Choices: []FlowChoice{
+				{Label: "Small", Next: "done"},
+				{Label: "Large", Next: "done"},
+			},
+			Patterns: []FlowPattern{{
+				Pattern: regexp.MustCompile(`(?i)^(?P<count>\d+) large$`),
+				Next:    "confirm",
+			}},
+		}, {
+			Name:      "confirm",
+			Text:      "Are you sure?",
+			Choices:   []FlowChoice{{Label: "Yes", Next: "done"}},
+			Otherwise: "size",
+			OnTimeout: "done",
+		}, {
+			Name: "done",
+			Text: "Thanks!",
+		}},
+	}
+}
+
+func TestFlowStep(t *testing.T) {
+	tests := []struct {
+		state string
+		input string
+		next  string
+		ok    bool
+		vars  map[string]string
+	}{
+		{"size", "Small", "done", true, nil},
+		{"size", "  large ", "done", true, nil},
+		{"size", "3 large", "confirm", true, map[string]string{"count": "3"}},
+		{"size", "medium", "", false, nil},
+		{"confirm", "yes", "done", true, nil},
+		{"confirm", "what?", "size", true, nil},
+		{"done", "anything", "", false, nil},
+		{"missing", "Small", "", false, nil},
+	}
+
+	flow := testFlow()
+	for _, test := range tests {
+		run := flow.NewRun()
+		run.State = test.state
+
+		next, ok := flow.Step(run, test.input)
+		if next != test.next || ok != test.ok {
+			t.Errorf("Step(%s, %q) = %q, %v, want %q, %v", test.state, test.input, next, ok, test.next, test.ok)
+		}
+		for name, want := range test.vars {
+			if got := run.Vars[name]; got != want {
+				t.Errorf("Step(%s, %q) set %s to %q, want %q", test.state, test.input, name, got, want)
+			}
+		}
+	}
+}
+
+func TestFlowValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		flow  func() *Flow
+		error string
+	}{
+		{"valid", testFlow, ""},
+		{"unknown start", func() *Flow {
+			flow := testFlow()
+			flow.Start = "nope"
+			return flow
+		}, "unknown start state nope"},
+		{"duplicate state", func() *Flow {
+			flow := testFlow()
+			flow.States = append(flow.States, &FlowState{Name: "done"})
+			return flow
+		}, "duplicate state done"},
+		{"unknown choice", func() *Flow {
+			flow := testFlow()
+			flow.States[0].Choices[0].Next = "nope"
+			return flow
+		}, "state size leads to unknown state nope"},
+		{"unknown timeout", func() *Flow {
+			flow := testFlow()
+			flow.States[1].OnTimeout = "nope"
+			return flow
+		}, "state confirm leads to unknown state nope"},
+	}
+
+	for _, test := range tests {
+		err := test.flow().Validate()
+		if test.error == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.error)
+		}
+	}
+}
+
+func TestFlowDOT(t *testing.T) {
+	dot := testFlow().DOT()
+
+	for _, want := range []string{
+		`digraph "order" {`,
+		`__start -> "size";`,
+		`"size" -> "done" [label="Small"];`,
+		`"size" -> "confirm" [label="/(?i)^(?P<count>\\d+) large$/", style=dashed];`,
+		`"confirm" -> "size" [label="otherwise", style=dashed];`,
+		`"confirm" -> "done" [label="timeout", style=dotted];`,
+		`"done" [shape=doublecircle];`,
+		`"size" [shape=box];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT is missing %s:\n%s", want, dot)
+		}
+	}
+}
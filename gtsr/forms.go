@@ -0,0 +1,366 @@
+package gtsr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// BackPhrase is what the user types (or clicks) during a form to go
+// back and answer the previous question again
+const BackPhrase = "back"
+
+// errBack is returned by parse functions when the user wants to go
+// back to the previous question of a form
+var errBack = fmt.Errorf("go back")
+
+// A FieldType decides how a FormField is asked and what type of value
+// it produces
+type FieldType int
+
+const (
+	// FieldText asks for free text and produces a string
+	FieldText FieldType = iota
+	// FieldNumber asks for a number between Min and Max and produces
+	// a float64. It can fill any int, uint or float struct field, and
+	// only takes numbers that fit in it
+	FieldNumber
+	// FieldYesNo asks a yes or no question and produces a bool
+	FieldYesNo
+	// FieldChoice asks to pick one of Options and produces a string
+	FieldChoice
+	// FieldDate asks for a calendar date and produces a time.Time
+	FieldDate
+)
+
+// A FormField is a single question in a Form
+type FormField struct {
+	// Name of the struct field the answer is stored in
+	Name string
+	// Short name shown on the summary page, defaults to Name
+	Label string
+	// Question asked to the user
+	Prompt string
+	Type   FieldType
+
+	// Possible answers for FieldChoice
+	Options []string
+	// Bounds for FieldNumber, inclusive. Leaving both zero means any
+	// number that fits the struct field
+	Min, Max float64
+	// Extra validation for FieldText, the error message is shown to
+	// the user before asking again
+	Validate func(string) error
+
+	// The field is only asked if Condition returns true for the answers
+	// given so far, keyed by Name. Nil means always ask
+	Condition func(answers map[string]interface{}) bool
+}
+
+// A Form collects a set of answers over DM and stores them in a struct.
+// Users can type "back" to revisit the previous question, and review
+// and edit all of their answers on a summary page before submitting
+type Form struct {
+	// Shown at the top of the summary page
+	Title  string
+	Fields []*FormField
+}
+
+type formState struct {
+	form *Form
+	// The struct the answers go in
+	dest reflect.Value
+
+	answers map[string]interface{}
+	display map[string]string
+	// Names of answered fields, in the order they were answered
+	history []string
+}
+
+// RunForm walks the user through the form and fills in dest, which
+// must be a pointer to a struct with a field for every FormField.
+// ErrCancelled is returned if the user cancels on the summary page
+func (msngr *Messenger) RunForm(form *Form, dest interface{}) error {
+	err := form.checkDest(dest)
+	if err != nil {
+		return err
+	}
+
+	state := &formState{
+		form:    form,
+		dest:    reflect.ValueOf(dest).Elem(),
+		answers: make(map[string]interface{}),
+		display: make(map[string]string),
+	}
+
+	for {
+		field := state.next()
+		if field != nil {
+			err = msngr.askField(state, field)
+			if err == errBack {
+				state.back()
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			state.prune()
+			continue
+		}
+
+		done, err := msngr.confirmForm(state)
+		if err != nil {
+			return err
+		}
+		if done {
+			return form.fill(state.answers, dest)
+		}
+	}
+}
+
+func (msngr *Messenger) askField(state *formState, field *FormField) error {
+	var value interface{}
+	canGoBack := len(state.history) > 0
+
+	question := field.Prompt
+	if canGoBack && field.Type != FieldYesNo && field.Type != FieldChoice {
+		question += fmt.Sprintf(" _(or type %q)_", BackPhrase)
+	}
+
+	prompt := func(text string) *OutgoingMessage {
+		msg := msngr.NewMessage(text)
+		switch field.Type {
+		case FieldYesNo:
+			msg.AddButton("Yes").AddButton("No")
+		case FieldChoice:
+			msg.addChoices(field.Options)
+		}
+		if canGoBack && (field.Type == FieldYesNo || field.Type == FieldChoice) {
+			msg.AddButton("Back")
+		}
+		return msg
+	}
+
+	parse := func(rsp string) (string, error) {
+		if canGoBack && strings.EqualFold(strings.TrimSpace(rsp), BackPhrase) {
+			return "", errBack
+		}
+
+		switch field.Type {
+		case FieldNumber:
+			min, max := field.Min, field.Max
+			if min == 0 && max == 0 {
+				min, max = math.Inf(-1), math.Inf(1)
+			}
+			n, err := parseNumber(rsp, min, max)
+			if err == nil {
+				err = numberFits(n, state.dest.FieldByName(field.Name).Type())
+			}
+			value = n
+			return strings.TrimSpace(rsp), err
+		case FieldYesNo:
+			answer, err := parseYesNo(rsp)
+			value = answer == "Yes"
+			return answer, err
+		case FieldChoice:
+			answer, err := parseChoice(rsp, field.Options)
+			value = answer
+			return answer, err
+		case FieldDate:
+			date, err := parseDate(rsp, time.Now())
+			value = date
+			return date.Format("Mon Jan 2, 2006"), err
+		}
+
+		rsp = strings.TrimSpace(rsp)
+		if field.Validate != nil {
+			if err := field.Validate(rsp); err != nil {
+				return "", err
+			}
+		}
+		value = rsp
+		return rsp, nil
+	}
+
+	answer, err := msngr.ask(prompt, question, parse)
+	if err != nil {
+		return err
+	}
+
+	state.answers[field.Name] = value
+	state.display[field.Name] = answer
+	state.history = append(state.history, field.Name)
+	return nil
+}
+
+// confirmForm shows the summary page. It returns true once the user
+// submits, and false if they chose a field to edit
+func (msngr *Messenger) confirmForm(state *formState) (bool, error) {
+	summary := "*" + state.form.Title + "*"
+	var labels []string
+	for _, field := range state.form.Fields {
+		if _, ok := state.answers[field.Name]; ok {
+			summary += fmt.Sprintf("\n• %s: %s", field.label(), state.display[field.Name])
+			labels = append(labels, field.label())
+		}
+	}
+
+	choice, err := msngr.AskChoice(summary, []string{"Submit", "Edit", "Cancel"})
+	if err != nil {
+		return false, err
+	}
+
+	switch choice {
+	case "Submit":
+		return true, nil
+	case "Cancel":
+		return false, ErrCancelled
+	}
+
+	label, err := msngr.AskChoice("Which answer do you want to change?", labels)
+	if err != nil {
+		return false, err
+	}
+	for _, field := range state.form.Fields {
+		if field.label() == label {
+			state.forget(field.Name)
+		}
+	}
+	return false, nil
+}
+
+// next returns the first field that should be asked but hasn't been
+// answered yet, or nil if the form is complete
+func (state *formState) next() *FormField {
+	for _, field := range state.form.Fields {
+		if _, ok := state.answers[field.Name]; ok {
+			continue
+		}
+		if field.Condition == nil || field.Condition(state.answers) {
+			return field
+		}
+	}
+	return nil
+}
+
+// back forgets the most recent answer so that it is asked again
+func (state *formState) back() {
+	if n := len(state.history); n > 0 {
+		state.forget(state.history[n-1])
+	}
+}
+
+func (state *formState) forget(name string) {
+	delete(state.answers, name)
+	delete(state.display, name)
+	for i, answered := range state.history {
+		if answered == name {
+			state.history = append(state.history[:i], state.history[i+1:]...)
+			break
+		}
+	}
+}
+
+// prune forgets answers to fields whose Condition no longer holds
+func (state *formState) prune() {
+	for _, field := range state.form.Fields {
+		if _, ok := state.answers[field.Name]; !ok || field.Condition == nil {
+			continue
+		}
+		if !field.Condition(state.answers) {
+			state.forget(field.Name)
+		}
+	}
+}
+
+func (field *FormField) label() string {
+	if field.Label != "" {
+		return field.Label
+	}
+	return field.Name
+}
+
+// checkDest makes sure every field of the form has somewhere to go
+// before the user spends any time answering
+func (form *Form) checkDest(dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form destination must be a pointer to a struct, got %T", dest)
+	}
+
+	for _, field := range form.Fields {
+		f := v.Elem().FieldByName(field.Name)
+		if !f.IsValid() || !f.CanSet() {
+			return fmt.Errorf("form field %s has no exported struct field in %T", field.Name, dest)
+		}
+		if !field.fits(f.Type()) {
+			return fmt.Errorf("form field %s can't be stored in a %s", field.Name, f.Type())
+		}
+	}
+	return nil
+}
+
+func (field *FormField) fits(t reflect.Type) bool {
+	switch field.Type {
+	case FieldNumber:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return true
+		}
+		return false
+	case FieldYesNo:
+		return t.Kind() == reflect.Bool
+	case FieldDate:
+		return t == reflect.TypeOf(time.Time{})
+	}
+	return t.Kind() == reflect.String
+}
+
+// numberFits checks that n can be stored in a struct field of type t
+// without losing anything
+func numberFits(n float64, t reflect.Type) error {
+	zero := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n != math.Trunc(n) {
+			return fmt.Errorf("It has to be a whole number")
+		}
+		if n < math.MinInt64 || n >= math.MaxInt64 || zero.OverflowInt(int64(n)) {
+			return fmt.Errorf("That number is out of range")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n != math.Trunc(n) {
+			return fmt.Errorf("It has to be a whole number")
+		}
+		if n < 0 {
+			return fmt.Errorf("It can't be negative")
+		}
+		if n >= math.MaxUint64 || zero.OverflowUint(uint64(n)) {
+			return fmt.Errorf("That number is out of range")
+		}
+	case reflect.Float32, reflect.Float64:
+		if zero.OverflowFloat(n) {
+			return fmt.Errorf("That number is out of range")
+		}
+	}
+	return nil
+}
+
+// fill stores the answers in dest, already checked by checkDest
+func (form *Form) fill(answers map[string]interface{}, dest interface{}) error {
+	v := reflect.ValueOf(dest).Elem()
+	for name, value := range answers {
+		f := v.FieldByName(name)
+		if n, ok := value.(float64); ok {
+			if err := numberFits(n, f.Type()); err != nil {
+				return fmt.Errorf("form field %s: %s", name, err)
+			}
+		}
+		f.Set(reflect.ValueOf(value).Convert(f.Type()))
+	}
+	return nil
+}
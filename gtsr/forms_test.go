@@ -0,0 +1,80 @@
+package gtsr
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestNumberFits(t *testing.T) {
+	var (
+		i   int
+		i8  int8
+		i64 int64
+		u   uint
+		u8  uint8
+		f32 float32
+		f64 float64
+	)
+
+	tests := []struct {
+		n    float64
+		into interface{}
+		ok   bool
+	}{
+		{42, i, true},
+		{-42, i, true},
+		{1.5, i, false},
+		{127, i8, true},
+		{128, i8, false},
+		{-128, i8, true},
+		{-129, i8, false},
+		{1e19, i64, false},
+		{math.MinInt64, i64, true},
+		{3, u, true},
+		{-1, u, false},
+		{0.5, u, false},
+		{255, u8, true},
+		{256, u8, false},
+		{1.5, f32, true},
+		{1e39, f32, false},
+		{1e39, f64, true},
+	}
+
+	for _, test := range tests {
+		err := numberFits(test.n, reflect.TypeOf(test.into))
+		if (err == nil) != test.ok {
+			t.Errorf("numberFits(%v, %T) = %v, want ok %v", test.n, test.into, err, test.ok)
+		}
+	}
+}
+
+func TestFormFill(t *testing.T) {
+	var dest struct {
+		Name  string
+		Count int8
+		Going bool
+	}
+
+	form := &Form{Fields: []*FormField{
+		{Name: "Name", Type: FieldText},
+		{Name: "Count", Type: FieldNumber},
+		{Name: "Going", Type: FieldYesNo},
+	}}
+	if err := form.checkDest(&dest); err != nil {
+		t.Fatalf("checkDest failed: %s", err)
+	}
+
+	err := form.fill(map[string]interface{}{"Name": "Ada", "Count": 12.0, "Going": true}, &dest)
+	if err != nil {
+		t.Fatalf("fill failed: %s", err)
+	}
+	if dest.Name != "Ada" || dest.Count != 12 || !dest.Going {
+		t.Errorf("fill stored %+v", dest)
+	}
+
+	err = form.fill(map[string]interface{}{"Count": 300.0}, &dest)
+	if err == nil {
+		t.Errorf("fill stored 300 in an int8, got %d", dest.Count)
+	}
+}
@@ -1,40 +1,55 @@
 package gtsr
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/nlopes/slack"
 )
 
-const noCallback = "NOCALLBACK"
+const (
+	noCallback = "NOCALLBACK"
+
+	// Length of generated callback IDs
+	callbackIDLen = 16
+	// How often expired callbacks are swept out of the listener
+	callbackSweepInterval = time.Minute
+
+	expiredText = "This prompt has expired"
+)
 
 // TODO(alex): better name pls
 type callbackListener struct {
-	callbacks map[string]*Messenger
+	callbacks map[string]*OutgoingMessage
+	dialogs   map[string]*pendingDialog
 
 	mutex *sync.Mutex
 }
 
-func (l *callbackListener) registerCallback(id string, msngr *Messenger) {
-	if id == noCallback {
-		return
-	}
-
+// registerCallback stores the message under a fresh callback ID, which
+// is returned. It stays registered until msg.expires
+func (l *callbackListener) registerCallback(msg *OutgoingMessage) string {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	if _, ok := l.callbacks[id]; ok {
-		panic("callback id collision")
+	id := randStringRunes(callbackIDLen)
+	for _, ok := l.callbacks[id]; ok; _, ok = l.callbacks[id] {
+		id = randStringRunes(callbackIDLen)
 	}
 
-	l.callbacks[id] = msngr
+	l.callbacks[id] = msg
+	return id
 }
 
 func (l *callbackListener) unregisterCallback(id string) {
-	if id == noCallback {
+	if id == noCallback || id == "" {
 		return
 	}
 
@@ -44,11 +59,95 @@ func (l *callbackListener) unregisterCallback(id string) {
 	delete(l.callbacks, id)
 }
 
+// sweep periodically drops expired callbacks until ctx is done
+func (l *callbackListener) sweep(ctx context.Context) {
+	ticker := time.NewTicker(callbackSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			l.mutex.Lock()
+			for id, msg := range l.callbacks {
+				if now.After(msg.expires) {
+					delete(l.callbacks, id)
+				}
+			}
+			for id, pending := range l.dialogs {
+				if now.After(pending.expires) {
+					delete(l.dialogs, id)
+				}
+			}
+			l.mutex.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// A responseMessage is posted to a response_url, or written straight
+// back as the response to an interaction
+type responseMessage struct {
+	Text            string             `json:"text,omitempty"`
+	ReplaceOriginal bool               `json:"replace_original"`
+	ResponseType    string             `json:"response_type,omitempty"`
+	Attachments     []slack.Attachment `json:"attachments,omitempty"`
+}
+
+// ack answers an interaction right away. Slack keeps the original
+// message as is unless rsp is given
+func ack(w http.ResponseWriter, rsp *responseMessage) {
+	if rsp == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rsp)
+}
+
+func postResponse(url string, rsp *responseMessage) error {
+	body, err := json.Marshal(rsp)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response_url returned %s", resp.Status)
+	}
+	return nil
+}
+
+// handleInteractiveMessages starts the HTTP server for callbacks from
+// Slack. It fails if the address can't be listened on. If the server
+// stops later on, the admin channel is told
 func (sb *SlackBot) handleInteractiveMessages() error {
+	http.HandleFunc(sb.http.InteractionPath, sb.interactionHandler)
+	http.HandleFunc(sb.http.SlashPath, sb.slashHandler)
+	http.HandleFunc(sb.http.OptionsPath, sb.optionsHandler)
 
-	http.HandleFunc("/", sb.interactionHandler)
-	http.ListenAndServe(":8080", nil)
+	ln, err := net.Listen("tcp", sb.http.Addr)
+	if err != nil {
+		return err
+	}
 
+	go func() {
+		err := http.Serve(ln, nil)
+		text := "Stopped listening for buttons and slash commands: " + err.Error()
+		fmt.Println(text)
+		if admin := sb.AdminChannel(); admin != "" {
+			_, err = sb.gm.scope(admin).NewMessage(text).Send()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
 	return nil
 }
 
@@ -57,21 +156,45 @@ func (sb *SlackBot) interactionHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
-		// TODO(nussey): don't panic
-		panic(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
 	}
 
 	payload := r.PostFormValue("payload") // to get params value with key
 
-	var callback slack.AttachmentActionCallback
-	json.Unmarshal([]byte(payload), &callback)
+	// Fields older versions of the slack package don't know about
+	var envelope struct {
+		Type      string `json:"type"`
+		Token     string `json:"token"`
+		TriggerID string `json:"trigger_id"`
+	}
+	err = json.Unmarshal([]byte(payload), &envelope)
 	if err != nil {
-		// TODO(nussey): don't panic
-		panic(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
 	}
 
-	if callback.Token != sb.token {
+	if envelope.Token != sb.token {
 		fmt.Println("garbage or illegal callback handled")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if envelope.Type == "dialog_submission" || envelope.Type == "dialog_cancellation" {
+		var dialog dialogCallback
+		err = json.Unmarshal([]byte(payload), &dialog)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		sb.handleDialog(w, &dialog)
+		return
+	}
+
+	var callback slack.AttachmentActionCallback
+	err = json.Unmarshal([]byte(payload), &callback)
+	if err != nil || len(callback.Actions) == 0 {
+		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
@@ -84,20 +207,174 @@ func (sb *SlackBot) interactionHandler(w http.ResponseWriter, r *http.Request) {
 		actionID = action.SelectedOptions[0].Value
 	} else {
 		fmt.Println("failed to parse callback")
+		ack(w, nil)
 		return
 	}
 
 	if actionID == noCallback {
 		fmt.Println("no-op")
+		ack(w, nil)
+		return
+	}
+
+	if strings.HasPrefix(actionID, topicLinkPrefix) {
+		go func() {
+			err := sb.startTopicTriggered(callback.User.Name, strings.TrimPrefix(actionID, topicLinkPrefix), envelope.TriggerID)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}()
+		ack(w, nil)
+		return
+	}
+
+	sb.gm.listener.mutex.Lock()
+	msg := sb.gm.listener.callbacks[callbackID]
+	if msg != nil && time.Now().After(msg.expires) {
+		delete(sb.gm.listener.callbacks, callbackID)
+		msg = nil
+	}
+	sb.gm.listener.mutex.Unlock()
+
+	if msg == nil {
+		ack(w, &responseMessage{
+			Text:            callback.OriginalMessage.Text,
+			ReplaceOriginal: true,
+			Attachments: []slack.Attachment{{
+				Color: ColorWarning,
+				Text:  expiredText,
+			}},
+		})
+		return
+	}
+
+	event := &Event{
+		Kind: EventInteraction,
+		User: callback.User.Name,
+		Text: actionID,
+	}
+	if callback.Channel.ID != "" && callback.Channel.ID[0] != dm {
+		event.Channel = callback.Channel.Name
+	}
+
+	var reply *Reply
+	err = sb.handle(event, func(*Event) error {
+		reply = msg.respond(action.Name, actionID, callback.User.Name, callback.ResponseURL, envelope.TriggerID)
+		return nil
+	})
+	if err != nil {
+		reason := denial(err)
+		if reason == "" {
+			fmt.Println(err)
+			reason = "Sorry, something went wrong"
+		}
+		ack(w, &responseMessage{
+			Text:         reason,
+			ResponseType: "ephemeral",
+		})
+		return
+	}
+	if reply == nil || !msg.lockOnClick {
+		ack(w, nil)
+		return
+	}
+
+	// Swap the buttons for the answer before the script even sees it
+	ack(w, &responseMessage{
+		Text:            msg.currentText(),
+		ReplaceOriginal: true,
+		Attachments: []slack.Attachment{{
+			Color: ColorGood,
+			Text:  reply.Text,
+		}},
+	})
+}
+
+// An optionsRequest is sent by Slack when a user types into an
+// external dropdown
+type optionsRequest struct {
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	CallbackID string `json:"callback_id"`
+	Token      string `json:"token"`
+}
+
+// optionsHandler serves the options of external dropdowns from the
+// OptionsProvider of the message they belong to
+func (sb *SlackBot) optionsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var req optionsRequest
+	err = json.Unmarshal([]byte(r.PostFormValue("payload")), &req)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token != sb.token {
+		fmt.Println("garbage or illegal options request handled")
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
 	sb.gm.listener.mutex.Lock()
-	defer sb.gm.listener.mutex.Unlock()
+	msg := sb.gm.listener.callbacks[req.CallbackID]
+	sb.gm.listener.mutex.Unlock()
+
+	options := []slack.AttachmentActionOption{}
+	if msg != nil {
+		options = append(options, msg.options(req.Name, req.Value)...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]slack.AttachmentActionOption{
+		"options": options,
+	})
+}
+
+// slashHandler starts topics from a slash command, as in "/clippy faq".
+// The command itself can be named anything in the Slack app settings
+func (sb *SlackBot) slashHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 
-	if sb.gm.listener.callbacks[callbackID] == nil {
-		fmt.Println("unregistered callback!")
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if r.PostFormValue("token") != sb.token {
+		fmt.Println("garbage or illegal slash command handled")
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	sb.gm.listener.callbacks[callbackID].respond(actionID, true)
+
+	user, ok := sb.user(r.PostFormValue("user_id"))
+	if !ok {
+		fmt.Fprint(w, "Sorry, I don't know who you are yet!")
+		return
+	}
+
+	if help, plugin := helpRequest(r.PostFormValue("text")); help {
+		fmt.Fprint(w, sb.Help(user.Name, plugin))
+		return
+	}
+
+	err = sb.startTopicTriggered(user.Name, r.PostFormValue("text"), r.PostFormValue("trigger_id"))
+	if reason := denial(err); reason != "" {
+		fmt.Fprint(w, reason)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Sorry, I don't know anything about %q", r.PostFormValue("text"))
+		return
+	}
+
+	fmt.Fprint(w, "Check your DMs!")
 }
@@ -0,0 +1,190 @@
+package gtsr
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// The word that asks the SlackBot for help, as in "@clippy help" or
+// "/clippy help poll"
+const helpCommand = "help"
+
+// SetPermissions tells the SlackBot what each user is allowed to do.
+// lookup may return nil for users without any permissions. Until this
+// is called nobody has any. Call this before ServeSlack()
+func (sb *SlackBot) SetPermissions(lookup func(user string) *Permissions) {
+	if sb.running {
+		panic("Set permissions before starting the Slack Bot")
+	}
+
+	sb.permissions = lookup
+}
+
+// Permissions returns what the user is allowed to do, never nil
+func (sb *SlackBot) Permissions(user string) *Permissions {
+	if sb.permissions == nil {
+		return &Permissions{}
+	}
+	if perms := sb.permissions(user); perms != nil {
+		return perms
+	}
+	return &Permissions{}
+}
+
+// helpRequest returns true if text asks for help, along with the plugin
+// the user wants to know more about, if any
+func helpRequest(text string) (bool, string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], helpCommand) {
+		return false, ""
+	}
+	return true, strings.Join(fields[1:], " ")
+}
+
+// Help returns the plugin directory as seen by user, or the details of
+// a single plugin if one is named. Topics the user doesn't have the
+// permissions for are left out
+func (sb *SlackBot) Help(user string, plugin string) string {
+	perms := sb.Permissions(user)
+	if plugin == "" {
+		return sb.directory(perms)
+	}
+
+	lp := sb.findPlugin(plugin)
+	if lp == nil || !lp.visible(perms) {
+		return fmt.Sprintf("I don't have a plugin called %q. %s", plugin, sb.helpHint())
+	}
+	return sb.pluginHelp(lp, perms)
+}
+
+func (sb *SlackBot) findPlugin(ref string) *loadedPlugin {
+	for _, lp := range sb.plugins {
+		if strings.EqualFold(lp.config.ID, ref) || strings.EqualFold(lp.config.Name, ref) {
+			return lp
+		}
+	}
+	return nil
+}
+
+// visible returns false for plugins that only have topics the user
+// isn't allowed to start
+func (lp *loadedPlugin) visible(perms *Permissions) bool {
+	config := lp.config
+	if len(config.Handlers) > 0 || !config.FeatureConvo || len(config.Topics) == 0 {
+		return true
+	}
+
+	for _, topic := range config.Topics {
+		if topic.Permissions == nil || perms.satisfies(topic.Permissions) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sb *SlackBot) directory(perms *Permissions) string {
+	var buf bytes.Buffer
+	buf.WriteString("*Here's everything I can do:*")
+
+	for _, lp := range sb.plugins {
+		if !lp.visible(perms) {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n• *%s* v%s (`%s`) - %s", lp.config.Name, lp.config.Version, lp.config.ID, lp.config.Description)
+	}
+
+	buf.WriteString("\n" + sb.helpHint())
+	return buf.String()
+}
+
+func (sb *SlackBot) helpHint() string {
+	return fmt.Sprintf("_Say `@%s %s <plugin>` to learn more about one_", sb.rtm.GetInfo().User.Name, helpCommand)
+}
+
+func (sb *SlackBot) pluginHelp(lp *loadedPlugin, perms *Permissions) string {
+	config := lp.config
+	name := sb.rtm.GetInfo().User.Name
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%s* v%s (`%s`)\n%s", config.Name, config.Version, config.ID, config.Description)
+
+	var topics []string
+	if config.FeatureConvo {
+		for _, topic := range config.Topics {
+			if topic.Permissions != nil && !perms.satisfies(topic.Permissions) {
+				continue
+			}
+			topics = append(topics, fmt.Sprintf("%s - `@%s %s`", topic.Label, name, sb.topicRef(topic)))
+		}
+	}
+	writeSection(&buf, "Conversations", topics)
+
+	var commands, triggers []string
+	for _, h := range config.Handlers {
+		if h.Mention {
+			commands = append(commands, h.help())
+		} else {
+			triggers = append(triggers, h.help())
+		}
+	}
+	writeSection(&buf, "Commands", commands)
+	writeSection(&buf, "Listens for", triggers)
+
+	var jobs []string
+	if config.FeatureCron {
+		for _, job := range config.Jobs {
+			jobs = append(jobs, fmt.Sprintf("%s (`%s`)", job.Name, job.Spec))
+		}
+	}
+	writeSection(&buf, "Scheduled jobs", jobs)
+
+	return buf.String()
+}
+
+// topicRef returns the shortest way to refer to a topic
+func (sb *SlackBot) topicRef(topic *ConvoTopic) string {
+	if sb.findTopic(topic.ID) == topic {
+		return strings.ToLower(topic.ID)
+	}
+	return topic.key
+}
+
+func writeSection(buf *bytes.Buffer, title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+
+	buf.WriteString("\n*" + title + "*")
+	for _, line := range lines {
+		buf.WriteString("\n• " + line)
+	}
+}
+
+// mentionHelp answers "@clippy help" in a channel over DM, since the
+// help depends on the permissions of whoever asked. Returns true if the
+// message was handled
+func (sb *SlackBot) mentionHelp(ev *slack.MessageEvent) bool {
+	mention := "<@" + sb.rtm.GetInfo().User.ID + ">"
+	if !strings.HasPrefix(ev.Text, mention) {
+		return false
+	}
+
+	user, ok := sb.user(ev.User)
+	if !ok {
+		return false
+	}
+
+	help, plugin := helpRequest(strings.TrimLeft(strings.TrimPrefix(ev.Text, mention), ": "))
+	if !help {
+		return false
+	}
+
+	_, err := sb.gm.scope("@" + user.Name).NewMessage(sb.Help(user.Name, plugin)).Send()
+	if err != nil {
+		fmt.Println(err)
+	}
+	return true
+}
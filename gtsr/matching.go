@@ -0,0 +1,153 @@
+package gtsr
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Lowest score a topic needs to be considered a match at all
+	minMatchScore = 0.7
+	// Score above which a match is trusted without asking the user
+	confidentMatchScore = 0.9
+	// A runner up this close to the best match makes it ambiguous
+	ambiguityMargin = 0.1
+	// Most "did you mean" suggestions to show at once
+	maxSuggestions = 3
+	// Score for a word that is the start of a longer one ("conv")
+	prefixScore = 0.85
+	// Shortest word that counts as a prefix
+	minPrefixLen = 3
+)
+
+type topicMatch struct {
+	topic *ConvoTopic
+	score float64
+}
+
+// matchTopics scores every topic the user is allowed to start against
+// the text of a message, returning the matches best first
+func (sb *SlackBot) matchTopics(user string, text string) []topicMatch {
+	words := tokenize(text)
+	if len(words) == 0 {
+		return nil
+	}
+
+	perms := sb.Permissions(user)
+	var matches []topicMatch
+	for _, topic := range sb.topics {
+		if !perms.allows(topic) {
+			continue
+		}
+		score := scoreTopic(words, topic)
+		if score >= minMatchScore {
+			matches = append(matches, topicMatch{topic: topic, score: score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].topic.Label < matches[j].topic.Label
+	})
+
+	return matches
+}
+
+// confident reports whether the best match can be started without
+// asking the user what they meant
+func confident(matches []topicMatch) bool {
+	if len(matches) == 0 || matches[0].score < confidentMatchScore {
+		return false
+	}
+	return len(matches) == 1 || matches[0].score-matches[1].score > ambiguityMargin
+}
+
+// scoreTopic compares the words of a message against the ID, Label and
+// Keywords of a topic. A term scores 1 if all of its words appear in the
+// message, and proportionally less for typos and partial matches
+func scoreTopic(words []string, topic *ConvoTopic) float64 {
+	terms := append([]string{topic.ID, topic.Label}, topic.Keywords...)
+
+	best := 0.0
+	for _, term := range terms {
+		termWords := tokenize(term)
+		if len(termWords) == 0 {
+			continue
+		}
+
+		total := 0.0
+		for _, tw := range termWords {
+			wordBest := 0.0
+			for _, w := range words {
+				if sim := similarity(w, tw); sim > wordBest {
+					wordBest = sim
+				}
+			}
+			total += wordBest
+		}
+
+		if score := total / float64(len(termWords)); score > best {
+			best = score
+		}
+	}
+
+	return best
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// similarity of word to term is 1 minus their edit distance, normalized
+// by the length of the longer string. Abbreviations of the term get
+// a fixed score instead
+func similarity(word, term string) float64 {
+	if word == term {
+		return 1
+	}
+
+	ra, rb := []rune(word), []rune(term)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	sim := 1 - float64(levenshtein(ra, rb))/float64(longest)
+	if len(ra) >= minPrefixLen && strings.HasPrefix(term, word) && sim < prefixScore {
+		sim = prefixScore
+	}
+	return sim
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,143 @@
+package gtsr
+
+import (
+	"math"
+	"testing"
+)
+
+func testTopics() map[string]*ConvoTopic {
+	topics := []*ConvoTopic{{
+		ID:       "faq",
+		Label:    "Frequently Asked Questions",
+		Keywords: []string{"help", "question"},
+	}, {
+		ID:       "killer",
+		Label:    "Conversation Killer",
+		Keywords: []string{"kill"},
+
+		Permissions: &Permissions{Admin: true},
+	}, {
+		ID:       "poll",
+		Label:    "Start a Poll",
+		Keywords: []string{"vote", "survey"},
+	}}
+
+	byKey := make(map[string]*ConvoTopic)
+	for _, topic := range topics {
+		topic.key = "test." + topic.ID
+		byKey[topic.key] = topic
+	}
+	return byKey
+}
+
+func TestScoreTopic(t *testing.T) {
+	topic := &ConvoTopic{ID: "poll", Label: "Start a Poll", Keywords: []string{"vote", "survey"}}
+
+	tests := []struct {
+		text string
+		min  float64
+		max  float64
+	}{
+		{"poll", 1, 1},
+		{"can we vote on lunch", 1, 1},
+		{"start a poll please", 1, 1},
+		// The start of a word
+		{"pol", prefixScore, prefixScore},
+		{"surveys", 0.8, 0.9},
+		{"weather", 0, minMatchScore - 0.01},
+		{"", 0, 0},
+	}
+
+	for _, test := range tests {
+		score := scoreTopic(tokenize(test.text), topic)
+		if score < test.min || score > test.max {
+			t.Errorf("scoreTopic(%q) = %v, want between %v and %v", test.text, score, test.min, test.max)
+		}
+	}
+}
+
+func TestConfident(t *testing.T) {
+	a := &ConvoTopic{Label: "A"}
+	b := &ConvoTopic{Label: "B"}
+
+	tests := []struct {
+		name    string
+		matches []topicMatch
+		want    bool
+	}{
+		{"none", nil, false},
+		{"single strong", []topicMatch{{a, 1}}, true},
+		{"single weak", []topicMatch{{a, confidentMatchScore - 0.01}}, false},
+		{"clear winner", []topicMatch{{a, 1}, {b, 0.8}}, true},
+		{"close runner up", []topicMatch{{a, 1}, {b, 0.95}}, false},
+		{"exactly the margin", []topicMatch{{a, 1}, {b, 1 - ambiguityMargin}}, false},
+	}
+
+	for _, test := range tests {
+		if got := confident(test.matches); got != test.want {
+			t.Errorf("%s: confident = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchTopics(t *testing.T) {
+	sb := &SlackBot{
+		topics: testTopics(),
+		permissions: func(user string) *Permissions {
+			return &Permissions{Admin: user == "admin"}
+		},
+	}
+
+	tests := []struct {
+		user string
+		text string
+		want []string
+	}{
+		{"someone", "I have a question", []string{"faq"}},
+		{"someone", "lets vote", []string{"poll"}},
+		{"admin", "kill it", []string{"killer"}},
+		// Admin only topics don't show up for everyone else
+		{"someone", "kill it", nil},
+		{"someone", "skill", nil},
+		{"someone", "", nil},
+	}
+
+	for _, test := range tests {
+		matches := sb.matchTopics(test.user, test.text)
+
+		var got []string
+		for _, match := range matches {
+			got = append(got, match.topic.ID)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("matchTopics(%s, %q) = %v, want %v", test.user, test.text, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("matchTopics(%s, %q) = %v, want %v", test.user, test.text, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		word string
+		term string
+		want float64
+	}{
+		{"poll", "poll", 1},
+		{"conv", "conversation", prefixScore},
+		// Too short to count as a prefix
+		{"co", "conversation", 1 - 10.0/12},
+		{"kitten", "sitting", 1 - 3.0/7},
+	}
+
+	for _, test := range tests {
+		if got := similarity(test.word, test.term); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("similarity(%q, %q) = %v, want %v", test.word, test.term, got, test.want)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package gtsr
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nlopes/slack"
@@ -21,18 +23,55 @@ const (
 	ColorDanger = "danger"
 
 	DefaultTimeout = time.Minute * 15
+
+	// How long the buttons and dropdowns of a message keep working
+	interactiveTTL = DefaultTimeout
 )
 
+// A GlobalMessenger is not bound to any channel. It is handed to cron
+// jobs so they can start conversations or post to any channel by
+// scoping a Messenger of their own
 type GlobalMessenger struct {
-	API *slack.Client
+	API    *slack.Client
+	apikey string
+
+	// Cancelled when the SlackBot shuts down
+	ctx context.Context
+
+	userIds    map[string]string
+	channelIds map[string]string
+	idMutex    *sync.RWMutex
+
+	dms         map[string]*directMessage
+	dmMutex     *sync.RWMutex
+	queuePolicy QueuePolicy
+	listener    *callbackListener
+}
 
-	userIds map[string]string
+// dm returns the direct message channel of a user
+func (gm *GlobalMessenger) dm(user string) (*directMessage, bool) {
+	gm.dmMutex.RLock()
+	defer gm.dmMutex.RUnlock()
 
-	dms      map[string]*directMessage
-	listener *callbackListener
+	dm, ok := gm.dms[user]
+	return dm, ok
+}
+
+// allDms returns a snapshot of every direct message channel
+func (gm *GlobalMessenger) allDms() []*directMessage {
+	gm.dmMutex.RLock()
+	defer gm.dmMutex.RUnlock()
+
+	dms := make([]*directMessage, 0, len(gm.dms))
+	for _, dm := range gm.dms {
+		dms = append(dms, dm)
+	}
+	return dms
 }
 
 func (gm *GlobalMessenger) mapIds(users map[string]*slack.User) {
+	gm.idMutex.Lock()
+	defer gm.idMutex.Unlock()
 
 	gm.userIds = make(map[string]string)
 
@@ -41,16 +80,167 @@ func (gm *GlobalMessenger) mapIds(users map[string]*slack.User) {
 	}
 }
 
+func (gm *GlobalMessenger) mapChannels(channels map[string]*slack.Channel) {
+	gm.idMutex.Lock()
+	defer gm.idMutex.Unlock()
+
+	gm.channelIds = make(map[string]string)
+
+	for k, channel := range channels {
+		gm.channelIds[channel.Name] = k
+	}
+}
+
+// channelID resolves a channel name ("general", "#general"), a user
+// name ("@nussey") or a raw Slack ID to the Slack ID of the channel
+func (gm *GlobalMessenger) channelID(channel string) (string, error) {
+	gm.idMutex.RLock()
+	defer gm.idMutex.RUnlock()
+
+	if channel == "" {
+		return "", fmt.Errorf("empty channel")
+	}
+
+	switch channel[0] {
+	case '@':
+		if id, ok := gm.userIds[channel[1:]]; ok {
+			return id, nil
+		}
+		return "", fmt.Errorf("no direct message open with %s", channel)
+	case '#':
+		channel = channel[1:]
+	}
+
+	if id, ok := gm.channelIds[channel]; ok {
+		return id, nil
+	}
+
+	for _, id := range gm.channelIds {
+		if id == channel {
+			return id, nil
+		}
+	}
+	for _, id := range gm.userIds {
+		if id == channel {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown channel %s", channel)
+}
+
+// channelName is the inverse of channelID, returning the human friendly
+// name of the channel
+func (gm *GlobalMessenger) channelName(id string) string {
+	gm.idMutex.RLock()
+	defer gm.idMutex.RUnlock()
+
+	for name, cid := range gm.channelIds {
+		if cid == id {
+			return name
+		}
+	}
+	for name, uid := range gm.userIds {
+		if uid == id {
+			return "@" + name
+		}
+	}
+
+	return id
+}
+
+// Scope returns a Messenger bound to the given channel. The channel can be
+// given by name ("general" or "#general"), as a user to DM ("@nussey") or
+// by its Slack ID. This is how cron jobs post outside of conversations
+func (gm *GlobalMessenger) Scope(channel string) (*Messenger, error) {
+	id, err := gm.channelID(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	return gm.scope(gm.channelName(id)), nil
+}
+
 // A Messenger provides scope, tracks state, and allows the sending
 // of messages to the scoped channel
 type Messenger struct {
 	channel string
 	gm      *GlobalMessenger
+	ctx     context.Context
 
 	lastMessage *OutgoingMessage
 
-	callbackID string
-	mailbox    chan string
+	mailbox chan *Reply
+	// ID of the most recent interaction, needed to open dialogs.
+	// Guarded by the listener mutex
+	trigger string
+}
+
+// A Reply is a single answer delivered to a Messenger, either typed out
+// in the DM or clicked on one of the interactive messages it sent
+type Reply struct {
+	// The typed text, or the label of the button or option picked
+	Text string
+	// Name of the button or dropdown that was used, empty if typed
+	Action string
+	// The message that was clicked, nil if typed
+	Message *MessageRef
+	// Name of the user that answered
+	User string
+
+	// Lets the script respond to the click without the Web API
+	responseURL string
+	original    string
+	trigger     string
+}
+
+// Interactive reports whether the reply came from a button or dropdown
+// rather than a typed message
+func (reply *Reply) Interactive() bool {
+	return reply.Message != nil
+}
+
+// ReplaceOriginal swaps the interactive components of the clicked
+// message for plain text, through the interaction's response URL.
+// This is quicker than UpdateLastMessage, but only works for a few
+// minutes after the click
+func (reply *Reply) ReplaceOriginal(text string, color string) error {
+	if reply.responseURL == "" {
+		return fmt.Errorf("reply is not from an interactive message")
+	}
+
+	return postResponse(reply.responseURL, &responseMessage{
+		Text:            reply.original,
+		ReplaceOriginal: true,
+		Attachments: []slack.Attachment{{
+			Color: color,
+			Text:  text,
+		}},
+	})
+}
+
+// Append posts a new message right after the clicked one through the
+// interaction's response URL, leaving the original alone
+func (reply *Reply) Append(text string) error {
+	if reply.responseURL == "" {
+		return fmt.Errorf("reply is not from an interactive message")
+	}
+
+	return postResponse(reply.responseURL, &responseMessage{
+		Text:         text,
+		ResponseType: "in_channel",
+	})
+}
+
+// Context returns the context the Messenger is operating in. For
+// conversations this is the same context handed to the script
+func (msngr *Messenger) Context() context.Context {
+	return msngr.ctx
+}
+
+// Global returns the GlobalMessenger the Messenger was scoped from
+func (msngr *Messenger) Global() *GlobalMessenger {
+	return msngr.gm
 }
 
 // ChannelName returns the human friendly name of the channel in scope
@@ -72,21 +262,57 @@ type OutgoingMessage struct {
 	messenger *Messenger
 	channel   string
 
-	sent bool
-	ts   string
+	ref *MessageRef
+	// Clicks after this are ignored
+	expires time.Time
+	// Replace the buttons with the answer as soon as one is clicked
+	lockOnClick bool
+	// Set when many users answer the message, see GroupSession
+	session *GroupSession
+	// Serve the options of external menus, keyed by menu name
+	providers map[string]OptionsProvider
+}
+
+// A MessageRef points at a message the SlackBot has already sent. It
+// holds the real channel ID and timestamp returned by Slack, so the
+// message can be updated, deleted or reacted to from any channel
+type MessageRef struct {
+	// Slack ID of the channel the message lives in
+	Channel string
+	// Slack timestamp of the message, unique within the channel
+	Timestamp string
+
+	// Guards text, which scripts update while clicks read it
+	mutex *sync.Mutex
+	text  string
+	gm    *GlobalMessenger
+}
+
+func (ref *MessageRef) getText() string {
+	ref.mutex.Lock()
+	defer ref.mutex.Unlock()
+
+	return ref.text
+}
+
+func (ref *MessageRef) setText(text string) {
+	ref.mutex.Lock()
+	ref.text = text
+	ref.mutex.Unlock()
 }
 
 func (gm *GlobalMessenger) scope(channel string) *Messenger {
 	return &Messenger{
 		gm:      gm,
 		channel: channel,
+		ctx:     gm.ctx,
 
-		mailbox: make(chan string, 1),
+		mailbox: make(chan *Reply, 1),
 	}
 }
 
 // Don't call this, use a regular messenger
-func (gm *GlobalMessenger) sendMessage(msg *OutgoingMessage) error {
+func (gm *GlobalMessenger) sendMessage(msg *OutgoingMessage) (*MessageRef, error) {
 	if msg.callbackID == "" {
 		msg.callbackID = "NOCALLBACK"
 	}
@@ -94,59 +320,140 @@ func (gm *GlobalMessenger) sendMessage(msg *OutgoingMessage) error {
 	params := slack.PostMessageParameters{
 		AsUser: true,
 		Attachments: []slack.Attachment{slack.Attachment{
-			Actions: msg.actions,
-			// CallbackID: randStringRunes(8),
+			Actions:    msg.actions,
 			CallbackID: msg.callbackID,
 		}},
 	}
 
-	_, ts, err := gm.API.PostMessage(msg.channel, msg.text, params)
+	channelID, ts, err := gm.API.PostMessage(msg.channel, msg.text, params)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	msg.sent = true
-	msg.ts = ts
+	msg.ref = &MessageRef{
+		Channel:   channelID,
+		Timestamp: ts,
 
+		mutex: &sync.Mutex{},
+		text:  msg.text,
+		gm:    gm,
+	}
+
+	return msg.ref, nil
+}
+
+// updateInteractive replaces the text of a sent message, keeping its
+// buttons and dropdowns working
+func (gm *GlobalMessenger) updateInteractive(msg *OutgoingMessage, text string) error {
+	attach := slack.Attachment{
+		Actions:    msg.actions,
+		CallbackID: msg.callbackID,
+	}
+	_, _, _, err := gm.API.SendMessageContext(context.Background(), msg.ref.Channel, slack.MsgOptionUpdate(msg.ref.Timestamp), slack.MsgOptionText(text, false), slack.MsgOptionAttachments(attach))
+	if err != nil {
+		return err
+	}
+
+	msg.ref.setText(text)
 	return nil
 }
 
-func (gm *GlobalMessenger) updateMessage(msg *OutgoingMessage, newText string, color string) error {
-	if !msg.sent {
-		return nil
+// currentText returns the text of the message as it is shown in
+// Slack, which may have been updated since it was sent
+func (msg *OutgoingMessage) currentText() string {
+	if msg.ref == nil {
+		return msg.text
 	}
+	return msg.ref.getText()
+}
 
-	// TODO(nussey): make this wwaaayyyy less brittle
-	channel := gm.userIds[msg.channel[1:]]
+// Update replaces the interactive components of the message with
+// plain text in an attachment of the given color. The original
+// message text is kept
+func (ref *MessageRef) Update(text string, color string) error {
 	attach := slack.Attachment{
 		Color: color,
-		Text:  newText,
+		Text:  text,
 	}
-	_, _, _, err := gm.API.SendMessageContext(context.Background(), channel, slack.MsgOptionUpdate(msg.ts), slack.MsgOptionText(msg.text, true), slack.MsgOptionAttachments(attach))
+	_, _, _, err := ref.gm.API.SendMessageContext(context.Background(), ref.Channel, slack.MsgOptionUpdate(ref.Timestamp), slack.MsgOptionText(ref.getText(), true), slack.MsgOptionAttachments(attach))
 	return err
 }
 
-func (msngr *Messenger) sendMessage(msg *OutgoingMessage) error {
-	callbackID := randStringRunes(8)
-	msngr.gm.listener.registerCallback(callbackID, msngr)
-	msg.callbackID = callbackID
-	return msngr.gm.sendMessage(msg)
+// UpdateText replaces the text of the message, dropping any attachments
+func (ref *MessageRef) UpdateText(text string) error {
+	_, _, _, err := ref.gm.API.SendMessageContext(context.Background(), ref.Channel, slack.MsgOptionUpdate(ref.Timestamp), slack.MsgOptionText(text, false), slack.MsgOptionAttachments())
+	if err != nil {
+		return err
+	}
+
+	ref.setText(text)
+	return nil
+}
+
+// Delete removes the message from the channel
+func (ref *MessageRef) Delete() error {
+	_, _, err := ref.gm.API.DeleteMessage(ref.Channel, ref.Timestamp)
+	return err
+}
+
+// AddReaction makes the SlackBot react to its own message. The react
+// should be specified without :
+func (ref *MessageRef) AddReaction(react string) error {
+	return ref.gm.API.AddReaction(react, slack.ItemRef{
+		Channel:   ref.Channel,
+		Timestamp: ref.Timestamp,
+	})
+}
+
+func (msngr *Messenger) sendMessage(msg *OutgoingMessage) (*MessageRef, error) {
+	if msg.interactive {
+		if msg.expires.IsZero() {
+			msg.expires = time.Now().Add(interactiveTTL)
+		}
+		msg.callbackID = msngr.gm.listener.registerCallback(msg)
+	}
+
+	ref, err := msngr.gm.sendMessage(msg)
+	if err != nil {
+		msngr.gm.listener.unregisterCallback(msg.callbackID)
+	}
+	return ref, err
 }
 
 // UpdateLastMessage replaces the interactive components of the last
 // message sent with plain text. This is not required, but is generally
 // preferable from a UX perspective.
 func (msngr *Messenger) UpdateLastMessage(text string, color string) error {
-	return msngr.gm.updateMessage(msngr.lastMessage, text, color)
+	ref := msngr.LastMessage()
+	if ref == nil {
+		return nil
+	}
+	return ref.Update(text, color)
+}
+
+// DeleteLastMessage removes the last message sent by the Messenger
+// from the channel
+func (msngr *Messenger) DeleteLastMessage() error {
+	ref := msngr.LastMessage()
+	if ref == nil {
+		return nil
+	}
+	msngr.gm.listener.unregisterCallback(msngr.lastMessage.callbackID)
+	return ref.Delete()
+}
+
+// LastMessage returns a reference to the last message sent by the
+// Messenger, or nil if it has not sent one yet
+func (msngr *Messenger) LastMessage() *MessageRef {
+	if msngr.lastMessage == nil {
+		return nil
+	}
+	return msngr.lastMessage.ref
 }
 
 // NewMessage creates a new OutgoingMessage within the scope of the
 // Messenger.
 func (msngr *Messenger) NewMessage(text string) *OutgoingMessage {
-	if msngr.lastMessage != nil {
-		msngr.gm.listener.unregisterCallback(msngr.lastMessage.callbackID)
-	}
-
 	msg := &OutgoingMessage{
 		text:    text,
 		channel: msngr.channel,
@@ -163,35 +470,86 @@ func (msngr *Messenger) NewMessage(text string) *OutgoingMessage {
 // AwaitResponse blocks unti the last message is responded to - only
 // available during conversations. Returns true if the conversation
 // should continue, false if not. If true, the second return contains
-// the user's answer
+// the user's answer, otherwise it is "timeout" or "cancelled"
 func (msngr *Messenger) AwaitResponse() (bool, string) {
 	return msngr.AwaitRespondseTimeout(DefaultTimeout)
 }
 
+// AwaitRespondseTimeout is AwaitResponse with a custom timeout. It
+// returns early if the conversation is cancelled
 func (msngr *Messenger) AwaitRespondseTimeout(timeout time.Duration) (bool, string) {
-	select {
-	case resp := <-msngr.mailbox:
-		return true, resp
-	case <-time.After(timeout):
+	reply, err := msngr.AwaitReply(timeout)
+	if err == ErrCancelled {
+		return false, "cancelled"
+	}
+	if err != nil {
 		return false, "timeout"
 	}
+	return true, reply.Text
+}
+
+// AwaitReply is AwaitRespondseTimeout with the full Reply, which tells
+// which message and action the answer came from. Clicks on any of the
+// Messenger's unexpired messages are delivered, not just the last one.
+// The error is ErrTimeout or ErrCancelled
+func (msngr *Messenger) AwaitReply(timeout time.Duration) (*Reply, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-msngr.mailbox:
+		return reply, nil
+	case <-msngr.ctx.Done():
+		return nil, ErrCancelled
+	case <-timer.C:
+		return nil, ErrTimeout
+	}
 }
 
-func (msngr *Messenger) respond(text string, interactive bool) {
-	if interactive {
-		text = msngr.lastMessage.elements[text]
+func (msngr *Messenger) respond(reply *Reply) {
+	if reply.trigger != "" {
+		msngr.gm.listener.mutex.Lock()
+		msngr.trigger = reply.trigger
+		msngr.gm.listener.mutex.Unlock()
 	}
+
 	// This can probably be much more robust - right now if multiple
 	// responses are recieved, all but the first is dropped on the ground
 	select {
-	case msngr.mailbox <- text:
+	case msngr.mailbox <- reply:
 	default:
 	}
 }
 
-// Send generates metadata and sends the OutgoingMessage to slack. It can
-// possibly return an error
-func (msg *OutgoingMessage) Send() error {
+// respond delivers a click on one of the message's elements
+func (msg *OutgoingMessage) respond(action string, elementID string, user string, responseURL string, trigger string) *Reply {
+	text, ok := msg.elements[elementID]
+	if !ok {
+		// Pickers and external menus send their value as is
+		text = elementID
+	}
+
+	reply := &Reply{
+		Text:    text,
+		Action:  action,
+		Message: msg.ref,
+		User:    user,
+
+		responseURL: responseURL,
+		original:    msg.currentText(),
+		trigger:     trigger,
+	}
+	if msg.session != nil {
+		msg.session.record(reply)
+		return reply
+	}
+	msg.messenger.respond(reply)
+	return reply
+}
+
+// Send generates metadata and sends the OutgoingMessage to slack. The
+// returned MessageRef can be used to edit the message later on
+func (msg *OutgoingMessage) Send() (*MessageRef, error) {
 	return msg.messenger.sendMessage(msg)
 }
 
@@ -214,11 +572,42 @@ func (msg *OutgoingMessage) AddButton(label string) *OutgoingMessage {
 	return msg
 }
 
+// LockOnClick makes the message swap its buttons and dropdowns for the
+// chosen answer the moment it is clicked, without waiting on the script.
+// The original message pointer is returned to allow method chaining
+func (msg *OutgoingMessage) LockOnClick() *OutgoingMessage {
+	msg.lockOnClick = true
+	return msg
+}
+
+// AddTopicButton creates a button that starts a conversation about
+// a topic with whoever clicks it, instead of answering the message.
+// topic is a "pluginid.topicid" key or an unambiguous topic ID. The
+// original message pointer is returned to allow method chaining
+func (msg *OutgoingMessage) AddTopicButton(label string, topic string) *OutgoingMessage {
+	msg.interactive = true
+
+	action := slack.AttachmentAction{
+		Name:  label,
+		Text:  label,
+		Value: topicLinkPrefix + topic,
+		Type:  "button",
+	}
+	msg.actions = append(msg.actions, action)
+
+	return msg
+}
+
 // AddDropdown creates an interactive dropdown menu on the message
 // label is the default text that will appear before a selection is
 // made. The original message pointer is returned to allow
 // method chaining
 func (msg *OutgoingMessage) AddDropdown(label string, options []string) *OutgoingMessage {
+	if len(options) > maxMenuOptions {
+		// Slack rejects menus this big, let the user search instead
+		return msg.AddExternalDropdown(label, 0, staticOptions(options))
+	}
+
 	msg.interactive = true
 
 	action := slack.AttachmentAction{
@@ -248,8 +637,11 @@ type IncomingMessage struct {
 	// The textual contents of the message
 	Text string
 
+	user      string
 	channel   string
 	timestamp string
+	// Named captures of the MessageHandler pattern that matched
+	captures map[string]string
 
 	sb *SlackBot
 }
@@ -262,10 +654,21 @@ func (inmsg *IncomingMessage) TimeStamp() time.Time {
 	return time.Unix(int64(millis), 0)
 }
 
+// User returns the name of the user that sent the message
+func (inmsg *IncomingMessage) User() string {
+	return inmsg.user
+}
+
 // Channel returns the human readable name of the channel of the
 // IncomingMessage was sent in/to
 func (inmsg *IncomingMessage) Channel() string {
-	return inmsg.sb.channels[inmsg.channel].Name
+	return inmsg.sb.channelName(inmsg.channel)
+}
+
+// Capture returns the text matched by the named group of the
+// MessageHandler pattern, or "" if there is no such group
+func (inmsg *IncomingMessage) Capture(name string) string {
+	return inmsg.captures[name]
 }
 
 // AddReaction makes the SlackBot add react reaction to the
@@ -0,0 +1,235 @@
+package gtsr
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// An EventKind says what caused an Event
+type EventKind int
+
+const (
+	// EventMessage is a message in a channel, or a DM to the SlackBot
+	// that answers a conversation or starts small talk
+	EventMessage EventKind = iota
+	// EventConversation is a user starting a topic, from a DM, a button,
+	// a mention or a slash command
+	EventConversation
+	// EventInteraction is a user clicking a button or picking from a
+	// dropdown of a message that is still waiting for an answer
+	EventInteraction
+)
+
+func (kind EventKind) String() string {
+	switch kind {
+	case EventMessage:
+		return "message"
+	case EventConversation:
+		return "conversation"
+	case EventInteraction:
+		return "interaction"
+	}
+	return "unknown"
+}
+
+// An Event is something a user did, on its way to the plugins
+type Event struct {
+	Kind EventKind
+	// Name of the user behind the event
+	User string
+	// Name of the channel, empty for DMs
+	Channel string
+	// Text of the message, or value of the button or option picked
+	Text string
+	// Topic being started, only set for EventConversation
+	Topic *ConvoTopic
+	// The message came from another bot or integration
+	Bot bool
+}
+
+// An EventHandler handles an Event that made it through the middleware
+type EventHandler func(*Event) error
+
+// Middleware wraps the handling of every Event, like HTTP middleware.
+// It can look at the event, stop it by returning without calling next,
+// or do something after next returns
+type Middleware func(next EventHandler) EventHandler
+
+// A Denial is returned by middleware that stops an event on purpose.
+// Where possible the Reason is shown to the user instead of being
+// logged as an error
+type Denial struct {
+	Reason string
+}
+
+func (d *Denial) Error() string {
+	return d.Reason
+}
+
+// Deny stops an event, telling the user why
+func Deny(reason string) error {
+	return &Denial{Reason: reason}
+}
+
+// Use adds middleware to the SlackBot. Middleware runs in the order it
+// was added, before the event reaches any plugin. Make all of these
+// calls before ServeSlack()
+func (sb *SlackBot) Use(middleware ...Middleware) {
+	if sb.running {
+		panic("Add middleware before starting the Slack Bot")
+	}
+
+	sb.middleware = append(sb.middleware, middleware...)
+}
+
+// handle runs the event through the middleware and into final
+func (sb *SlackBot) handle(event *Event, final EventHandler) error {
+	handler := final
+	for i := len(sb.middleware) - 1; i >= 0; i-- {
+		handler = sb.middleware[i](handler)
+	}
+	return handler(event)
+}
+
+// denial returns the reason an event was denied, or "" if err isn't a
+// Denial
+func denial(err error) string {
+	if d, ok := err.(*Denial); ok {
+		return d.Reason
+	}
+	return ""
+}
+
+// IgnoreBots drops messages from other bots and integrations, so that
+// two bots can't get stuck talking to each other
+func IgnoreBots() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event *Event) error {
+			if event.Bot {
+				return nil
+			}
+			return next(event)
+		}
+	}
+}
+
+// DisableIn drops every event that happens in the given channels
+func DisableIn(channels ...string) Middleware {
+	disabled := make(map[string]bool)
+	for _, channel := range channels {
+		disabled[channel] = true
+	}
+
+	return func(next EventHandler) EventHandler {
+		return func(event *Event) error {
+			if event.Channel != "" && disabled[event.Channel] {
+				return nil
+			}
+			return next(event)
+		}
+	}
+}
+
+// RateLimit lets each user through at most limit times per period. Over
+// the limit, channel messages are dropped quietly and everything else
+// is denied
+func RateLimit(limit int, period time.Duration) Middleware {
+	mutex := &sync.Mutex{}
+	seen := make(map[string][]time.Time)
+
+	allow := func(user string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		now := time.Now()
+		recent := seen[user][:0]
+		for _, t := range seen[user] {
+			if now.Sub(t) < period {
+				recent = append(recent, t)
+			}
+		}
+
+		if len(recent) >= limit {
+			seen[user] = recent
+			return false
+		}
+		seen[user] = append(recent, now)
+		return true
+	}
+
+	return func(next EventHandler) EventHandler {
+		return func(event *Event) error {
+			if allow(event.User) {
+				return next(event)
+			}
+			if event.Kind == EventMessage && event.Channel != "" {
+				return nil
+			}
+			return Deny("Whoa, slow down! Try again in a little bit.")
+		}
+	}
+}
+
+// AuditLog writes a line for every event to w, along with any error
+// the plugins returned
+func AuditLog(w io.Writer) Middleware {
+	mutex := &sync.Mutex{}
+
+	return func(next EventHandler) EventHandler {
+		return func(event *Event) error {
+			err := next(event)
+
+			where := "DM"
+			if event.Channel != "" {
+				where = "#" + event.Channel
+			}
+			line := fmt.Sprintf("%s %s %s in %s: %q", time.Now().Format(time.RFC3339), event.Kind, event.User, where, event.Text)
+			if event.Topic != nil {
+				line += " topic=" + event.Topic.Key()
+			}
+			if err != nil {
+				line += " err=" + err.Error()
+			}
+
+			mutex.Lock()
+			fmt.Fprintln(w, line)
+			mutex.Unlock()
+
+			return err
+		}
+	}
+}
+
+// RequirePermissions denies users topics they don't have the Permissions
+// for. lookup returns the permissions of a user
+func RequirePermissions(lookup func(user string) *Permissions) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event *Event) error {
+			if event.Topic == nil || event.Topic.Permissions == nil {
+				return next(event)
+			}
+
+			if !lookup(event.User).satisfies(event.Topic.Permissions) {
+				return Deny("Sorry, you aren't allowed to use " + event.Topic.Label)
+			}
+			return next(event)
+		}
+	}
+}
+
+// allows returns true if perms are enough to start the topic
+func (perms *Permissions) allows(topic *ConvoTopic) bool {
+	return topic.Permissions == nil || perms.satisfies(topic.Permissions)
+}
+
+// satisfies returns true if perms has every permission required has
+func (perms *Permissions) satisfies(required *Permissions) bool {
+	if perms == nil {
+		perms = &Permissions{}
+	}
+	return (perms.Admin || !required.Admin) &&
+		(perms.Exec || !required.Exec) &&
+		(perms.SubteamLead || !required.SubteamLead)
+}
@@ -0,0 +1,405 @@
+package gtsr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Most options AskChoice will show as buttons before switching
+// to a dropdown
+const maxChoiceButtons = 5
+
+var (
+	// ErrTimeout is returned by the Ask helpers when the user doesn't
+	// answer within DefaultTimeout
+	ErrTimeout = fmt.Errorf("timed out waiting for a response")
+	// ErrCancelled is returned by the Ask helpers when the
+	// conversation is cancelled while waiting for an answer
+	ErrCancelled = fmt.Errorf("conversation was cancelled")
+)
+
+// Button that finishes the multiple choice prompts
+const doneLabel = "Done"
+
+var (
+	// Typed user mentions look like <@U024BE7LH> or <@U024BE7LH|bob>
+	userMentionReg = regexp.MustCompile(`^<@([UW][A-Z0-9]+)(\|[^>]*)?>$`)
+	// Typed channel mentions look like <#C024BE7LR|general>
+	channelMentionReg = regexp.MustCompile(`^<#([CG][A-Z0-9]+)(\|[^>]*)?>$`)
+	userIDReg         = regexp.MustCompile(`^[UW][A-Z0-9]+$`)
+	channelIDReg      = regexp.MustCompile(`^[CG][A-Z0-9]+$`)
+)
+
+// Layouts AskDate understands, tried in order
+var dateLayouts = []string{
+	"2006-01-02",
+	"1/2/2006",
+	"1/2/06",
+	"Jan 2 2006",
+	"January 2 2006",
+	"Jan 2",
+	"January 2",
+	"1/2",
+}
+
+// await is AwaitResponse with the failure turned into an error. The
+// prompt is marked as unanswered on failure
+func (msngr *Messenger) await() (string, error) {
+	reply, err := msngr.awaitReply()
+	if err != nil {
+		return "", err
+	}
+	return reply.Text, nil
+}
+
+// awaitReply is await with the full Reply
+func (msngr *Messenger) awaitReply() (*Reply, error) {
+	reply, err := msngr.AwaitReply(DefaultTimeout)
+	if err == nil {
+		return reply, nil
+	}
+
+	if err == ErrCancelled {
+		msngr.UpdateLastMessage("Cancelled", ColorDanger)
+		return nil, ErrCancelled
+	}
+
+	msngr.UpdateLastMessage("No answer", ColorDanger)
+	return nil, ErrTimeout
+}
+
+// ask keeps sending the prompt built by prompt until parse accepts the
+// answer. The prompt is then updated with the accepted answer
+func (msngr *Messenger) ask(prompt func(text string) *OutgoingMessage, question string, parse func(string) (string, error)) (string, error) {
+	text := question
+	for {
+		_, err := prompt(text).Send()
+		if err != nil {
+			return "", err
+		}
+
+		rsp, err := msngr.await()
+		if err != nil {
+			return "", err
+		}
+
+		answer, err := parse(rsp)
+		if err == nil {
+			msngr.UpdateLastMessage(answer, ColorGood)
+			return answer, nil
+		}
+		if err == errBack {
+			msngr.UpdateLastMessage("Back", ColorWarning)
+			return "", err
+		}
+
+		msngr.UpdateLastMessage(rsp, ColorWarning)
+		text = fmt.Sprintf("%s. %s", strings.TrimSuffix(err.Error(), "."), question)
+	}
+}
+
+// AskYesNo asks a yes or no question with buttons. The user may also
+// type their answer
+func (msngr *Messenger) AskYesNo(question string) (bool, error) {
+	prompt := func(text string) *OutgoingMessage {
+		return msngr.NewMessage(text).AddButton("Yes").AddButton("No")
+	}
+
+	answer, err := msngr.ask(prompt, question, parseYesNo)
+
+	return answer == "Yes", err
+}
+
+func parseYesNo(rsp string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(rsp)) {
+	case "yes", "y", "yep", "yeah", "sure":
+		return "Yes", nil
+	case "no", "n", "nope", "nah":
+		return "No", nil
+	}
+	return "", fmt.Errorf("I need a yes or a no")
+}
+
+// AskChoice asks the user to pick one of the options, as buttons for a
+// handful of options and as a dropdown for more. The user may also type
+// one of the options out
+func (msngr *Messenger) AskChoice(question string, options []string) (string, error) {
+	prompt := func(text string) *OutgoingMessage {
+		return msngr.NewMessage(text).addChoices(options)
+	}
+
+	return msngr.ask(prompt, question, func(rsp string) (string, error) {
+		return parseChoice(rsp, options)
+	})
+}
+
+func (msg *OutgoingMessage) addChoices(options []string) *OutgoingMessage {
+	if len(options) > maxChoiceButtons {
+		return msg.AddDropdown("Choose one", options)
+	}
+	for _, opt := range options {
+		msg.AddButton(opt)
+	}
+	return msg
+}
+
+func parseChoice(rsp string, options []string) (string, error) {
+	for _, opt := range options {
+		if strings.EqualFold(strings.TrimSpace(rsp), opt) {
+			return opt, nil
+		}
+	}
+	return "", fmt.Errorf("That isn't one of the options")
+}
+
+// AskText asks for a free form answer. If validate is not nil the
+// question is asked again until validate accepts the answer, and the
+// message of the error it returns is shown to the user
+func (msngr *Messenger) AskText(question string, validate func(string) error) (string, error) {
+	prompt := func(text string) *OutgoingMessage {
+		return msngr.NewMessage(text)
+	}
+
+	return msngr.ask(prompt, question, func(rsp string) (string, error) {
+		rsp = strings.TrimSpace(rsp)
+		if validate != nil {
+			if err := validate(rsp); err != nil {
+				return "", err
+			}
+		}
+		return rsp, nil
+	})
+}
+
+// AskNumber asks for a number between min and max, inclusive
+func (msngr *Messenger) AskNumber(question string, min, max float64) (float64, error) {
+	var num float64
+	_, err := msngr.AskText(question, func(rsp string) error {
+		n, err := parseNumber(rsp, min, max)
+		if err != nil {
+			return err
+		}
+		num = n
+		return nil
+	})
+
+	return num, err
+}
+
+func parseNumber(text string, min, max float64) (float64, error) {
+	n, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(text), ",", "", -1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("That isn't a number")
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("It has to be between %v and %v", min, max)
+	}
+	return n, nil
+}
+
+// AskDate asks for a calendar date. Besides the usual formats the user
+// can answer "today", "tomorrow" or the name of a weekday, which means
+// the next one to come. Dates without a year are assumed to be in the
+// current year
+func (msngr *Messenger) AskDate(question string) (time.Time, error) {
+	var date time.Time
+	_, err := msngr.AskText(question, func(rsp string) error {
+		d, err := parseDate(rsp, time.Now())
+		if err != nil {
+			return err
+		}
+		date = d
+		return nil
+	})
+
+	return date, err
+}
+
+func parseDate(text string, now time.Time) (time.Time, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch text {
+	case "today":
+		return today, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	}
+
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if text == strings.ToLower(day.String()) {
+			ahead := (int(day) - int(today.Weekday()) + 7) % 7
+			if ahead == 0 {
+				ahead = 7
+			}
+			return today.AddDate(0, 0, ahead), nil
+		}
+	}
+
+	text = strings.Replace(text, ",", "", -1)
+	for _, layout := range dateLayouts {
+		date, err := time.ParseInLocation(layout, strings.Title(text), now.Location())
+		if err != nil {
+			continue
+		}
+		if date.Year() == 0 {
+			// Year 0 is a leap year, so Feb 29 parses fine without one
+			// and has to be checked again against the current year
+			month, day := date.Month(), date.Day()
+			date = time.Date(today.Year(), month, day, 0, 0, 0, 0, now.Location())
+			if date.Day() != day {
+				return time.Time{}, fmt.Errorf("There is no %s %d in %d", month, day, today.Year())
+			}
+		}
+		return date, nil
+	}
+
+	return time.Time{}, fmt.Errorf("I don't understand that date, try something like %s", today.Format("2006-01-02"))
+}
+
+// AskUser asks the user to pick someone from the workspace, and returns
+// their user ID. Typing an @mention works too
+func (msngr *Messenger) AskUser(question string) (string, error) {
+	prompt := func(text string) *OutgoingMessage {
+		return msngr.NewMessage(text).AddUserPicker("Pick someone")
+	}
+
+	var id string
+	_, err := msngr.ask(prompt, question, func(rsp string) (string, error) {
+		var err error
+		id, err = parseUser(rsp)
+		return "<@" + id + ">", err
+	})
+
+	return id, err
+}
+
+// AskChannel asks the user to pick a channel, and returns its ID.
+// Typing a #channel works too
+func (msngr *Messenger) AskChannel(question string) (string, error) {
+	prompt := func(text string) *OutgoingMessage {
+		return msngr.NewMessage(text).AddChannelPicker("Pick a channel")
+	}
+
+	var id string
+	_, err := msngr.ask(prompt, question, func(rsp string) (string, error) {
+		var err error
+		id, err = parseChannel(rsp)
+		return "<#" + id + ">", err
+	})
+
+	return id, err
+}
+
+// AskMultiChoice asks the user to pick any number of the options. Each
+// pick toggles an option on or off until they click Done. Typing a
+// comma separated list works too
+func (msngr *Messenger) AskMultiChoice(question string, options []string) ([]string, error) {
+	build := func(msg *OutgoingMessage) {
+		msg.AddDropdown("Add or remove", options)
+	}
+	parse := func(rsp string) (string, error) {
+		return parseChoice(rsp, options)
+	}
+
+	return msngr.askMulti(question, build, parse, func(opt string) string {
+		return opt
+	})
+}
+
+// AskUsers asks the user to pick any number of people from the
+// workspace, and returns their user IDs
+func (msngr *Messenger) AskUsers(question string) ([]string, error) {
+	build := func(msg *OutgoingMessage) {
+		msg.AddUserPicker("Add or remove someone")
+	}
+	return msngr.askMulti(question, build, parseUser, func(id string) string {
+		return "<@" + id + ">"
+	})
+}
+
+// askMulti keeps a single message open, toggling each answer accepted
+// by parse in and out of the selection until the user is done
+func (msngr *Messenger) askMulti(question string, build func(*OutgoingMessage), parse func(string) (string, error), show func(string) string) ([]string, error) {
+	msg := msngr.NewMessage(question)
+	build(msg)
+	msg.AddButton(doneLabel)
+
+	_, err := msg.Send()
+	if err != nil {
+		return nil, err
+	}
+
+	var picked []string
+	render := func() string {
+		var shown []string
+		for _, p := range picked {
+			shown = append(shown, show(p))
+		}
+		if len(shown) == 0 {
+			return "Nothing yet"
+		}
+		return strings.Join(shown, ", ")
+	}
+
+	for {
+		reply, err := msngr.awaitReply()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.EqualFold(strings.TrimSpace(reply.Text), doneLabel) {
+			msngr.UpdateLastMessage(render(), ColorGood)
+			return picked, nil
+		}
+
+		// Only typed lists are split, picked options can have commas
+		parts := []string{reply.Text}
+		if reply.Message == nil {
+			parts = strings.Split(reply.Text, ",")
+		}
+		for _, part := range parts {
+			answer, err := parse(part)
+			if err != nil {
+				continue
+			}
+			picked = toggle(picked, answer)
+		}
+
+		msngr.gm.updateInteractive(msg, question+"\n*Selected:* "+render())
+	}
+}
+
+func toggle(list []string, item string) []string {
+	for i, existing := range list {
+		if existing == item {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return append(list, item)
+}
+
+func parseUser(rsp string) (string, error) {
+	rsp = strings.TrimSpace(rsp)
+	if match := userMentionReg.FindStringSubmatch(rsp); match != nil {
+		return match[1], nil
+	}
+	if userIDReg.MatchString(rsp) {
+		return rsp, nil
+	}
+	return "", fmt.Errorf("Pick someone from the list, or @mention them")
+}
+
+func parseChannel(rsp string) (string, error) {
+	rsp = strings.TrimSpace(rsp)
+	if match := channelMentionReg.FindStringSubmatch(rsp); match != nil {
+		return match[1], nil
+	}
+	if channelIDReg.MatchString(rsp) {
+		return rsp, nil
+	}
+	return "", fmt.Errorf("Pick a channel from the list, or #mention it")
+}
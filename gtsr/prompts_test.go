@@ -0,0 +1,92 @@
+package gtsr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	// A Wednesday in a year that isn't a leap year
+	now := time.Date(2019, time.March, 13, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		text string
+		want string
+		ok   bool
+	}{
+		{"today", "2019-03-13", true},
+		{"Tomorrow", "2019-03-14", true},
+		{"friday", "2019-03-15", true},
+		// The same weekday means next week
+		{"wednesday", "2019-03-20", true},
+		{"2019-04-01", "2019-04-01", true},
+		{"Apr 1", "2019-04-01", true},
+		{"april 1, 2020", "2020-04-01", true},
+		{"4/1", "2019-04-01", true},
+		{"2/28", "2019-02-28", true},
+		{"Feb 29 2020", "2020-02-29", true},
+		// Only a leap year has these
+		{"Feb 29", "", false},
+		{"2/29", "", false},
+		{"Feb 30", "", false},
+		{"someday", "", false},
+	}
+
+	for _, test := range tests {
+		date, err := parseDate(test.text, now)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("parseDate(%q) = %s, want an error", test.text, date.Format("2006-01-02"))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDate(%q) failed: %s", test.text, err)
+			continue
+		}
+		if got := date.Format("2006-01-02"); got != test.want {
+			t.Errorf("parseDate(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParseDateLeapYear(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	date, err := parseDate("Feb 29", now)
+	if err != nil {
+		t.Fatalf("parseDate failed in a leap year: %s", err)
+	}
+	if got := date.Format("2006-01-02"); got != "2020-02-29" {
+		t.Errorf("parseDate = %s, want 2020-02-29", got)
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		min  float64
+		max  float64
+		want float64
+		ok   bool
+	}{
+		{"42", 0, 100, 42, true},
+		{" 1,000 ", 0, 5000, 1000, true},
+		{"-2.5", -10, 10, -2.5, true},
+		{"0", 0, 0, 0, true},
+		{"101", 0, 100, 0, false},
+		{"-1", 0, 100, 0, false},
+		{"lots", 0, 100, 0, false},
+	}
+
+	for _, test := range tests {
+		n, err := parseNumber(test.text, test.min, test.max)
+		if (err == nil) != test.ok {
+			t.Errorf("parseNumber(%q, %v, %v) error = %v, want ok %v", test.text, test.min, test.max, err, test.ok)
+			continue
+		}
+		if test.ok && n != test.want {
+			t.Errorf("parseNumber(%q, %v, %v) = %v, want %v", test.text, test.min, test.max, n, test.want)
+		}
+	}
+}
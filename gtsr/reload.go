@@ -0,0 +1,196 @@
+package gtsr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"github.com/nlopes/slack"
+)
+
+// The word that makes the SlackBot re-read its config, as in
+// "@clippy reload" in the admin channel
+const reloadCommand = "reload"
+
+// A ReconfigurablePlugin can switch to new settings without a restart.
+// Reconfigure is handed a fresh value of the same type as Settings,
+// already decoded and validated. It is called while the plugin is
+// running, so it must be threadsafe. Returning an error keeps the old
+// settings
+type ReconfigurablePlugin interface {
+	ConfigurablePlugin
+	Reconfigure(settings interface{}) error
+}
+
+// Reload re-reads the config file and pushes the new settings to every
+// ReconfigurablePlugin. Nothing changes if the new config is invalid,
+// including the settings of plugins that can't be reconfigured.
+// Changes that need a restart to take effect are listed in the notes
+func (sb *SlackBot) Reload() ([]string, error) {
+	sb.reloadMutex.Lock()
+	defer sb.reloadMutex.Unlock()
+
+	old := sb.currentConfig()
+	if old == nil {
+		return nil, fmt.Errorf("the Slack Bot wasn't created from a config")
+	}
+
+	cfg, err := LoadConfig(old.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode every section before touching anything, so a bad one
+	// leaves the running config alone
+	cerr := &ConfigError{Path: cfg.path}
+	updates := make(map[*loadedPlugin]interface{})
+	var restart []string
+	for _, lp := range sb.plugins {
+		section := cfg.Plugins[lp.config.ID]
+		if section == nil || !section.Enabled {
+			continue
+		}
+
+		cp, ok := lp.plugin.(ConfigurablePlugin)
+		if !ok {
+			if len(section.Settings) > 0 {
+				cerr.add("Plugins.%s.Settings: plugin has no settings", lp.config.ID)
+			}
+			continue
+		}
+
+		// Decode into a fresh value so the running settings are left
+		// alone, even for plugins that only read them at startup
+		settings := reflect.New(reflect.TypeOf(cp.Settings()).Elem()).Interface()
+		err := decodeInto(lp.config.ID, settings, section)
+		if err != nil {
+			cerr.add("%s", err)
+			continue
+		}
+		if _, ok := lp.plugin.(ReconfigurablePlugin); ok {
+			updates[lp] = settings
+		} else if was := old.Plugins[lp.config.ID]; was == nil || !bytes.Equal(was.Settings, section.Settings) {
+			restart = append(restart, fmt.Sprintf("%s settings changed, restart to use them", lp.config.ID))
+		}
+	}
+	if len(cerr.Problems) > 0 {
+		return nil, cerr
+	}
+
+	notes := append(restartNotes(old, cfg), restart...)
+	for _, lp := range sb.plugins {
+		settings, ok := updates[lp]
+		if !ok {
+			continue
+		}
+		err := lp.plugin.(ReconfigurablePlugin).Reconfigure(settings)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("%s kept its old settings: %s", lp.config.ID, err))
+		}
+	}
+
+	sb.configMutex.Lock()
+	sb.config = cfg
+	sb.configMutex.Unlock()
+
+	return notes, nil
+}
+
+// restartNotes lists the differences between two configs that only
+// take effect after a restart
+func restartNotes(old *Config, cfg *Config) []string {
+	var notes []string
+	if old.Slack != cfg.Slack {
+		notes = append(notes, "Slack credentials changed, restart to use them")
+	}
+	if old.HTTP != cfg.HTTP {
+		notes = append(notes, "HTTP settings changed, restart to use them")
+	}
+	if old.Workers != cfg.Workers {
+		notes = append(notes, "Workers changed, restart to use them")
+	}
+	if old.StateFile != cfg.StateFile {
+		notes = append(notes, "StateFile changed, restart to use it")
+	}
+	if strings.Join(old.EnabledPlugins(), ",") != strings.Join(cfg.EnabledPlugins(), ",") {
+		notes = append(notes, "Enabled plugins changed, restart to load or unload them")
+	}
+	return notes
+}
+
+func (sb *SlackBot) currentConfig() *Config {
+	sb.configMutex.RLock()
+	defer sb.configMutex.RUnlock()
+
+	return sb.config
+}
+
+// reload runs Reload and reports how it went in the admin channel
+func (sb *SlackBot) reload() string {
+	notes, err := sb.Reload()
+
+	var text string
+	if err != nil {
+		text = "Config reload failed, still running the old config:\n```" + err.Error() + "```"
+	} else {
+		text = "Config reloaded"
+		for _, note := range notes {
+			text += "\n• " + note
+		}
+	}
+
+	fmt.Println(text)
+	if admin := sb.AdminChannel(); admin != "" {
+		_, err = sb.gm.scope(admin).NewMessage(text).Send()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	return text
+}
+
+// reloadOnSignal reloads the config every time the process gets a
+// SIGHUP, until ctx is done
+func (sb *SlackBot) reloadOnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			sb.reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mentionReload handles "@clippy reload" from an admin in the admin
+// channel. Returns true if the message was handled
+func (sb *SlackBot) mentionReload(ev *slack.MessageEvent) bool {
+	mention := "<@" + sb.rtm.GetInfo().User.ID + ">"
+	if !strings.HasPrefix(ev.Text, mention) {
+		return false
+	}
+
+	text := strings.TrimSpace(strings.TrimLeft(strings.TrimPrefix(ev.Text, mention), ": "))
+	if !strings.EqualFold(text, reloadCommand) {
+		return false
+	}
+
+	admin := sb.AdminChannel()
+	user, ok := sb.user(ev.User)
+	if admin == "" || sb.channelName(ev.Channel) != admin || !ok || !sb.Permissions(user.Name).Admin {
+		return false
+	}
+
+	// reload reports back to the admin channel
+	sb.reload()
+	return true
+}
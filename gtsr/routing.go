@@ -0,0 +1,150 @@
+package gtsr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A MessageHandler routes channel messages to a plugin without the
+// plugin having to look at every message in ParseMessage. Every
+// criteria that is set must match for the handler to run
+type MessageHandler struct {
+	// What the handler does, shown in the help
+	Description string
+	// Example message that triggers the handler, shown in the help
+	Usage string
+
+	// Matched against the message text. Named captures are available
+	// through IncomingMessage.Capture
+	Pattern *regexp.Regexp
+	// Strings that must all appear in the message, ignoring case
+	Keywords []string
+	// Only match messages that start by mentioning the Slack Bot. The
+	// mention is stripped before checking Pattern and Keywords
+	Mention bool
+	// Names of the channels the handler listens in, every channel
+	// if empty
+	Channels []string
+
+	Action func(*IncomingMessage, *Messenger) error
+}
+
+// A loadedPlugin is a plugin along with the config it was added with
+type loadedPlugin struct {
+	plugin SlackPlugin
+	config *PluginConfig
+}
+
+// match checks the message against the handler, returning the named
+// captures of Pattern. The second return is false if it doesn't match
+func (h *MessageHandler) match(text string, channel string, mention string) (map[string]string, bool) {
+	if len(h.Channels) > 0 && !h.listensIn(channel) {
+		return nil, false
+	}
+
+	if h.Mention {
+		if !strings.HasPrefix(text, mention) {
+			return nil, false
+		}
+		text = strings.TrimLeft(strings.TrimPrefix(text, mention), ": ")
+	}
+
+	lower := strings.ToLower(text)
+	for _, keyword := range h.Keywords {
+		if !strings.Contains(lower, strings.ToLower(keyword)) {
+			return nil, false
+		}
+	}
+
+	captures := make(map[string]string)
+	if h.Pattern != nil {
+		match := h.Pattern.FindStringSubmatch(text)
+		if match == nil {
+			return nil, false
+		}
+		for i, name := range h.Pattern.SubexpNames() {
+			if name != "" {
+				captures[name] = match[i]
+			}
+		}
+	}
+
+	return captures, true
+}
+
+// mentionHandled returns true if a mention handler of a plugin turned
+// on in the channel matches text
+func (sb *SlackBot) mentionHandled(text string, channel string, mention string) bool {
+	for _, lp := range sb.plugins {
+		if !sb.switches.enabled(lp.config.ID, channel) {
+			continue
+		}
+		for _, h := range lp.config.Handlers {
+			if !h.Mention {
+				continue
+			}
+			if _, ok := h.match(text, channel, mention); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *MessageHandler) listensIn(channel string) bool {
+	for _, name := range h.Channels {
+		if strings.TrimPrefix(name, "#") == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMessage runs every handler of the plugin that matches msg
+func (sb *SlackBot) routeMessage(lp *loadedPlugin, msg *IncomingMessage, msngr *Messenger) {
+	mention := "<@" + sb.rtm.GetInfo().User.ID + ">"
+	channel := sb.channelName(msg.channel)
+
+	for _, h := range lp.config.Handlers {
+		captures, ok := h.match(msg.Text, channel, mention)
+		if !ok {
+			continue
+		}
+
+		routed := *msg
+		routed.captures = captures
+
+		// TODO(nussey): much better error handling
+		err := h.Action(&routed, msngr)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func (h *MessageHandler) help() string {
+	var trigger string
+	switch {
+	case h.Usage != "":
+		trigger = "`" + h.Usage + "`"
+	case h.Pattern != nil:
+		trigger = "`/" + h.Pattern.String() + "/`"
+	case len(h.Keywords) > 0:
+		trigger = "messages containing " + strings.Join(h.Keywords, " and ")
+	default:
+		trigger = "any message"
+	}
+	if h.Mention {
+		trigger = "@mention " + trigger
+	}
+
+	text := trigger
+	if h.Description != "" {
+		text += " - " + h.Description
+	}
+	if len(h.Channels) > 0 {
+		text += " _(in " + strings.Join(h.Channels, ", ") + ")_"
+	}
+	return text
+}
@@ -0,0 +1,167 @@
+package gtsr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrSessionClosed is returned by GroupSession.Next once the session
+// has closed and every answer has been read
+var ErrSessionClosed = fmt.Errorf("session is closed")
+
+// An Answer is a single response to a GroupSession
+type Answer struct {
+	// Name of the user that answered
+	User string
+	// Label of the button or option picked
+	Text string
+	// When the answer came in
+	At time.Time
+}
+
+// A GroupSession lets everyone in a channel answer the same interactive
+// message until it closes. Unlike a conversation, answers are never
+// dropped - the script reads them one by one with Next, or all at once
+// with Answers
+type GroupSession struct {
+	msg    *OutgoingMessage
+	closes time.Time
+
+	mutex   *sync.Mutex
+	answers []*Answer
+	// Index of the next answer handed out by Next
+	read int
+	// Signals Next that a new answer came in
+	updates chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// NewGroupSession sends msg, which should have buttons or a dropdown,
+// and collects answers from anyone in the channel until closes
+func (msngr *Messenger) NewGroupSession(msg *OutgoingMessage, closes time.Time) (*GroupSession, error) {
+	gs := &GroupSession{
+		msg:    msg,
+		closes: closes,
+
+		mutex:   &sync.Mutex{},
+		updates: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	msg.session = gs
+	msg.expires = closes
+
+	_, err := msg.Send()
+	if err != nil {
+		return nil, err
+	}
+
+	time.AfterFunc(time.Until(closes), gs.Close)
+	return gs, nil
+}
+
+func (gs *GroupSession) record(reply *Reply) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+
+	if gs.closed {
+		return
+	}
+
+	gs.answers = append(gs.answers, &Answer{
+		User: reply.User,
+		Text: reply.Text,
+		At:   time.Now(),
+	})
+
+	select {
+	case gs.updates <- struct{}{}:
+	default:
+	}
+}
+
+// Next blocks until somebody answers and returns their answer. Once the
+// session closes the remaining answers are handed out, followed by
+// ErrSessionClosed
+func (gs *GroupSession) Next(ctx context.Context) (*Answer, error) {
+	for {
+		gs.mutex.Lock()
+		if gs.read < len(gs.answers) {
+			answer := gs.answers[gs.read]
+			gs.read++
+			gs.mutex.Unlock()
+			return answer, nil
+		}
+		closed := gs.closed
+		gs.mutex.Unlock()
+
+		if closed {
+			return nil, ErrSessionClosed
+		}
+
+		select {
+		case <-gs.updates:
+		case <-gs.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Answers returns every answer so far, oldest first. Users may have
+// answered more than once
+func (gs *GroupSession) Answers() []*Answer {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+
+	answers := make([]*Answer, len(gs.answers))
+	copy(answers, gs.answers)
+	return answers
+}
+
+// Latest returns the most recent answer of every user, keyed by name
+func (gs *GroupSession) Latest() map[string]*Answer {
+	latest := make(map[string]*Answer)
+	for _, answer := range gs.Answers() {
+		latest[answer.User] = answer
+	}
+	return latest
+}
+
+// Closes returns the time the session stops taking answers
+func (gs *GroupSession) Closes() time.Time {
+	return gs.closes
+}
+
+// Done is closed once the session stops taking answers
+func (gs *GroupSession) Done() <-chan struct{} {
+	return gs.done
+}
+
+// UpdateText changes the text of the session's message while keeping it
+// open for answers, for example to show a running tally
+func (gs *GroupSession) UpdateText(text string) error {
+	return gs.msg.messenger.gm.updateInteractive(gs.msg, text)
+}
+
+// Message returns a reference to the session's message
+func (gs *GroupSession) Message() *MessageRef {
+	return gs.msg.ref
+}
+
+// Close stops taking answers early. It is safe to call more than once
+func (gs *GroupSession) Close() {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+
+	if gs.closed {
+		return
+	}
+	gs.closed = true
+	close(gs.done)
+
+	gs.msg.messenger.gm.listener.unregisterCallback(gs.msg.callbackID)
+}
@@ -0,0 +1,77 @@
+package gtsr
+
+import (
+	"sync"
+	"time"
+)
+
+// Status is a snapshot of how the SlackBot is doing
+type Status struct {
+	// When ServeSlack was called
+	Started time.Time
+	// When the RTM connection was last established, zero while
+	// disconnected
+	Connected time.Time
+	// Times the RTM connection was re-established after the first
+	Reconnects int
+
+	Plugins  int
+	CronJobs int
+	// Conversations in progress, and waiting behind them
+	Conversations       int
+	QueuedConversations int
+
+	Dispatch DispatchStats
+}
+
+// connection keeps track of the RTM connection for Status
+type connection struct {
+	mutex *sync.Mutex
+
+	started    time.Time
+	connected  time.Time
+	reconnects int
+}
+
+func (c *connection) up(count int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.connected = time.Now()
+	if count > 1 {
+		c.reconnects = count - 1
+	}
+}
+
+func (c *connection) down() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.connected = time.Time{}
+}
+
+// Status reports uptime, connection health and load of the SlackBot
+func (sb *SlackBot) Status() *Status {
+	sb.conn.mutex.Lock()
+	status := &Status{
+		Started:    sb.conn.started,
+		Connected:  sb.conn.connected,
+		Reconnects: sb.conn.reconnects,
+	}
+	sb.conn.mutex.Unlock()
+
+	status.Plugins = len(sb.plugins)
+	status.CronJobs = len(sb.crons)
+	status.Dispatch = sb.DispatchStats()
+
+	for _, dm := range sb.gm.allDms() {
+		dm.mutex.Lock()
+		if dm.currentConvo != nil {
+			status.Conversations++
+		}
+		status.QueuedConversations += len(dm.convoQueue)
+		dm.mutex.Unlock()
+	}
+
+	return status
+}
@@ -0,0 +1,219 @@
+package gtsr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pluginSwitches records the plugins admins turned off at runtime, and
+// saves them so they stay off across restarts
+type pluginSwitches struct {
+	mutex *sync.RWMutex
+	// File the switches are saved to, not saved at all if empty
+	path string
+
+	// Plugin IDs turned off everywhere
+	Global map[string]bool
+	// Plugin IDs turned off in a channel, by channel name
+	Channels map[string]map[string]bool
+}
+
+func newPluginSwitches() *pluginSwitches {
+	return &pluginSwitches{
+		mutex:    &sync.RWMutex{},
+		Global:   make(map[string]bool),
+		Channels: make(map[string]map[string]bool),
+	}
+}
+
+// loadPluginSwitches reads the switches saved at path. A missing file
+// means everything is on
+func loadPluginSwitches(path string) (*pluginSwitches, error) {
+	ps := newPluginSwitches()
+	ps.path = path
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(raw, ps)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if ps.Global == nil {
+		ps.Global = make(map[string]bool)
+	}
+	if ps.Channels == nil {
+		ps.Channels = make(map[string]map[string]bool)
+	}
+	return ps, nil
+}
+
+func (ps *pluginSwitches) enabled(id string, channel string) bool {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return !ps.Global[id] && !ps.Channels[channel][id]
+}
+
+// set turns a plugin on or off in a channel, or everywhere if channel
+// is empty, and saves the result. Nothing changes if it can't be saved
+func (ps *pluginSwitches) set(id string, channel string, on bool) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	wasOn := !ps.Global[id]
+	if channel != "" {
+		wasOn = !ps.Channels[channel][id]
+	}
+
+	ps.apply(id, channel, on)
+	err := ps.save()
+	if err != nil {
+		ps.apply(id, channel, wasOn)
+	}
+	return err
+}
+
+// LOCK BEFORE YOU USE THIS
+func (ps *pluginSwitches) apply(id string, channel string, on bool) {
+	if channel == "" {
+		if on {
+			delete(ps.Global, id)
+		} else {
+			ps.Global[id] = true
+		}
+		return
+	}
+
+	if on {
+		delete(ps.Channels[channel], id)
+		if len(ps.Channels[channel]) == 0 {
+			delete(ps.Channels, channel)
+		}
+	} else {
+		if ps.Channels[channel] == nil {
+			ps.Channels[channel] = make(map[string]bool)
+		}
+		ps.Channels[channel][id] = true
+	}
+}
+
+// save writes the switches to a temporary file first, so a crash can't
+// leave half a file behind
+func (ps *pluginSwitches) save() error {
+	if ps.path == "" {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(ps, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp := ps.path + ".tmp"
+	err = ioutil.WriteFile(tmp, raw, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, ps.path)
+}
+
+// describe lists where each plugin is turned off
+func (ps *pluginSwitches) describe(id string) []string {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	if ps.Global[id] {
+		return []string{"everywhere"}
+	}
+
+	var channels []string
+	for channel, off := range ps.Channels {
+		if off[id] {
+			channels = append(channels, "#"+channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// channelRef turns a channel ID, name or #name into the name of a
+// channel the SlackBot knows about
+func (sb *SlackBot) channelRef(ref string) (string, error) {
+	if name := sb.channelName(ref); name != "" {
+		return name, nil
+	}
+
+	name := strings.TrimPrefix(ref, "#")
+	if _, ok := sb.channelByName(name); !ok {
+		return "", fmt.Errorf("no channel %s", ref)
+	}
+	return name, nil
+}
+
+// SetPluginEnabled turns the plugin with the given ID on or off in a
+// channel, or everywhere if channel is empty. channel may be an ID or a
+// name. Turned off plugins don't hear about messages in the channel, but
+// their conversations, cron jobs and buttons keep working. The setting
+// is saved to the StateFile of the config
+func (sb *SlackBot) SetPluginEnabled(id string, channel string, on bool) error {
+	lp := sb.findPlugin(id)
+	if lp == nil {
+		return fmt.Errorf("no plugin %s", id)
+	}
+
+	if channel != "" {
+		name, err := sb.channelRef(channel)
+		if err != nil {
+			return err
+		}
+		channel = name
+	}
+	return sb.switches.set(lp.config.ID, channel, on)
+}
+
+// PluginEnabled returns true if the plugin with the given ID hears about
+// messages in the channel, by ID or name
+func (sb *SlackBot) PluginEnabled(id string, channel string) bool {
+	name, err := sb.channelRef(channel)
+	if err != nil {
+		// Only the global switch applies to unknown channels
+		name = strings.TrimPrefix(channel, "#")
+	}
+	return sb.switches.enabled(id, name)
+}
+
+// PluginSwitches describes which plugins are turned off where, one line
+// per plugin
+func (sb *SlackBot) PluginSwitches() string {
+	var lines []string
+	for _, lp := range sb.plugins {
+		off := sb.switches.describe(lp.config.ID)
+		if len(off) == 0 {
+			lines = append(lines, fmt.Sprintf("• *%s* (`%s`) is on everywhere", lp.config.Name, lp.config.ID))
+		} else {
+			lines = append(lines, fmt.Sprintf("• *%s* (`%s`) is off in %s", lp.config.Name, lp.config.ID, strings.Join(off, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PluginIDs returns the IDs of every loaded plugin, in the order they
+// were added
+func (sb *SlackBot) PluginIDs() []string {
+	var ids []string
+	for _, lp := range sb.plugins {
+		ids = append(ids, lp.config.ID)
+	}
+	return ids
+}
@@ -0,0 +1,148 @@
+package gtsr
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/robfig/cron"
+)
+
+var alphanumeric = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// A PluginError lists everything wrong with the config of a plugin
+type PluginError struct {
+	// ID of the plugin, or its type if it didn't get that far
+	Plugin   string
+	Problems []string
+}
+
+func (err *PluginError) Error() string {
+	return fmt.Sprintf("plugin %s: %s", err.Plugin, strings.Join(err.Problems, "; "))
+}
+
+func (err *PluginError) add(format string, args ...interface{}) {
+	err.Problems = append(err.Problems, fmt.Sprintf(format, args...))
+}
+
+// A pluginResult records how adding a plugin went, for the startup
+// report
+type pluginResult struct {
+	name string
+	err  error
+}
+
+// validatePlugin checks config against the documented invariants and
+// everything already loaded, without changing anything. The plugin must
+// have the expected ID, unless it is empty
+func (sb *SlackBot) validatePlugin(config *PluginConfig, expected string) *PluginError {
+	perr := &PluginError{Plugin: config.ID}
+
+	if !alphanumeric.MatchString(config.ID) {
+		perr.add("ID %q must be non empty and alphanumeric", config.ID)
+	}
+	if expected != "" && config.ID != expected {
+		perr.add("ID %q doesn't match its config section %s", config.ID, expected)
+	}
+	for _, lp := range sb.plugins {
+		if strings.EqualFold(lp.config.ID, config.ID) {
+			perr.add("ID %q is already used by %s", config.ID, lp.config.Name)
+		}
+	}
+
+	if config.FeatureConvo {
+		if len(config.Topics) == 0 {
+			perr.add("FeatureConvo is set but there are no Topics")
+		}
+
+		ids := make(map[string]bool)
+		labels := make(map[string]bool)
+		for _, topic := range config.Topics {
+			if !alphanumeric.MatchString(topic.ID) {
+				perr.add("topic ID %q must be non empty and alphanumeric", topic.ID)
+			}
+			if topic.Label == "" {
+				perr.add("topic %s has no Label", topic.ID)
+			}
+			if topic.Action == nil {
+				perr.add("topic %s has no Action", topic.ID)
+			}
+
+			if ids[strings.ToLower(topic.ID)] {
+				perr.add("topic ID %s is used twice", topic.ID)
+			}
+			if labels[topic.Label] || sb.topicByLabel(topic.Label) != nil {
+				perr.add("topic label %q is already used", topic.Label)
+			}
+			ids[strings.ToLower(topic.ID)] = true
+			labels[topic.Label] = true
+		}
+	}
+
+	if config.FeatureCron {
+		if len(config.Jobs) == 0 {
+			perr.add("FeatureCron is set but there are no Jobs")
+		}
+
+		ids := make(map[string]bool)
+		for _, job := range config.Jobs {
+			if _, ok := sb.crons[job.ID]; ok || ids[job.ID] {
+				perr.add("cron ID %q is already used", job.ID)
+			}
+			ids[job.ID] = true
+
+			if job.Action == nil {
+				perr.add("cron job %s has no Action", job.ID)
+			}
+			if _, err := cron.Parse(job.Spec); err != nil {
+				perr.add("cron job %s has a bad Spec %q: %s", job.ID, job.Spec, err)
+			}
+		}
+	}
+
+	for i, h := range config.Handlers {
+		if h.Action == nil {
+			perr.add("message handler %d has no Action", i)
+		}
+	}
+
+	if len(perr.Problems) > 0 {
+		return perr
+	}
+	return nil
+}
+
+// StartupReport lists every plugin that was added, or failed to be
+func (sb *SlackBot) StartupReport() string {
+	var loaded int
+	for _, result := range sb.results {
+		if result.err == nil {
+			loaded++
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*Loaded %d of %d plugins*", loaded, len(sb.results))
+	for _, result := range sb.results {
+		if result.err == nil {
+			fmt.Fprintf(&buf, "\n• :white_check_mark: %s", result.name)
+		} else {
+			fmt.Fprintf(&buf, "\n• :x: %s", result.err)
+		}
+	}
+	return buf.String()
+}
+
+// report prints the startup report and posts it to the admin channel
+func (sb *SlackBot) report() {
+	text := sb.StartupReport()
+	fmt.Println(text)
+
+	if admin := sb.AdminChannel(); admin != "" {
+		_, err := sb.gm.scope(admin).NewMessage(text).Send()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -1,38 +1,59 @@
 package main
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"flag"
+	"fmt"
+	"os"
 
 	"github.com/nussey/gtsr-slackbot/gtsr"
 	"github.com/nussey/gtsr-slackbot/plugins/helptext"
+	"github.com/nussey/gtsr-slackbot/plugins/poll"
 	"github.com/nussey/gtsr-slackbot/plugins/ryanbot"
+	"github.com/nussey/gtsr-slackbot/plugins/status"
 	"github.com/nussey/gtsr-slackbot/plugins/sysadmin"
 )
 
-const keyFileLocation = "./keys.json"
+var configFile = flag.String("config", "./config.json", "path to the config file")
 
-type KeysFile struct {
-	SlackAPIKey            string
-	SlackVerificationToken string
-}
-
-// Uptime plugin
+// Set at build time with
+// -ldflags "-X main.version=1.2 -X main.commit=$(git rev-parse --short HEAD)"
+var (
+	version string
+	commit  string
+)
 
 func main() {
-	raw, err := ioutil.ReadFile(keyFileLocation)
+	flag.Parse()
+
+	cfg, err := gtsr.LoadConfig(*configFile)
 	if err != nil {
-		panic(err)
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	var keys = &KeysFile{}
-	json.Unmarshal(raw, keys)
+	bot := gtsr.InitSlackConfig(cfg)
+
+	bot.Use(gtsr.IgnoreBots(), gtsr.AuditLog(os.Stdout), gtsr.RequirePermissions(bot.Permissions))
 
-	bot := gtsr.InitSlack(keys.SlackAPIKey, keys.SlackVerificationToken)
+	// Every plugin clippy knows about, keyed by ID. Which ones run is
+	// up to the config file
+	available := map[string]gtsr.SlackPlugin{
+		"helptext": &helptext.HelpTextBot{},
+		"poll":     &poll.PollBot{},
+		"ryanbot":  &ryanbot.RyanBot{},
+		"status":   &status.StatusBot{Bot: bot, Version: version, Commit: commit},
+		"sysadmin": &sysadmin.SysAdminBot{Bot: bot},
+	}
 
-	bot.AddPlugin(&helptext.HelpTextBot{})
-	bot.AddPlugin(&ryanbot.RyanBot{})
-	bot.AddPlugin(&sysadmin.SysAdminBot{})
+	// Bad plugins are left out and show up in the startup report
+	err = bot.AddConfiguredPlugins(available)
+	if err != nil {
+		fmt.Println(err)
+	}
 
-	bot.ServeSlack()
+	err = bot.ServeSlack()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
@@ -1,12 +1,39 @@
 package helptext
 
 import (
-	"strings"
+	"context"
+	"fmt"
+	"sync"
 
 	"github.com/nussey/gtsr-slackbot/gtsr"
 )
 
 type HelpTextBot struct {
+	// Guards settings, which can be swapped out by Reconfigure
+	mutex    sync.RWMutex
+	settings Settings
+}
+
+// Settings is the helptext section of the config file
+type Settings struct {
+	// Shown when someone asks about the network drive. Defaults to
+	// the GTSR drive instructions
+	NetworkDrive string
+	FAQ          []FAQEntry
+}
+
+type FAQEntry struct {
+	Question string
+	Answer   string
+}
+
+func (s *Settings) Validate() error {
+	for i, entry := range s.FAQ {
+		if entry.Question == "" || entry.Answer == "" {
+			return fmt.Errorf("FAQ entry %d needs both a Question and an Answer", i)
+		}
+	}
+	return nil
 }
 
 var networkDriveText = "*On Windows*: \n• Open a File Explorer window. \n• Right-click on ‘This PC’ and then select ‘Map Network Drive...’.  \n• Enter ‘\\\\mefile4.me.gatech.edu\\Research\\GTSR’ into the ‘Folder:’ field and then click ‘Finish’ (or hit Enter). \n• Enter your GT Prism ID as ‘AD\\<username>’ (e.g. ‘AD\\gburdell3’) and your password. \n\n*On OSX*:  \n• From the desktop, click ‘Go’ in the menu bar above all and then ‘Connect to Server’. \n• Enter ‘cifs://mefile4.me.gatech.edu/Research/GTSR’ into the ‘Server Address:’ field and then click ‘Connect’ (or hit Enter). \n• Enter your GT Prism ID (e.g. ‘gburdell3’) and your password."
@@ -15,16 +42,46 @@ var clippyText = "Hi"
 
 // TODO(nussey) maybe link out to the wiki one day
 
+func (ht *HelpTextBot) Settings() interface{} {
+	return &ht.settings
+}
+
+func (ht *HelpTextBot) Reconfigure(settings interface{}) error {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	ht.settings = *settings.(*Settings)
+	ht.settings.defaults()
+	return nil
+}
+
+func (ht *HelpTextBot) current() Settings {
+	ht.mutex.RLock()
+	defer ht.mutex.RUnlock()
+
+	return ht.settings
+}
+
+func (s *Settings) defaults() {
+	if s.NetworkDrive == "" {
+		s.NetworkDrive = networkDriveText
+	}
+}
+
 func (ht *HelpTextBot) Init() *gtsr.PluginConfig {
+	ht.settings.defaults()
+
 	faq := &gtsr.ConvoTopic{
 		ID:          "FAQ",
 		Label:       "Frequently Asked Questions",
+		Keywords:    []string{"help", "question"},
 		Permissions: &gtsr.Permissions{},
 
 		Action: ht.FAQ,
 	}
 
 	return &gtsr.PluginConfig{
+		ID:          "helptext",
 		Name:        "Help Text",
 		Description: "Let users get basic help information without bothering people",
 		Version:     "1.0",
@@ -34,6 +91,14 @@ func (ht *HelpTextBot) Init() *gtsr.PluginConfig {
 
 		FeatureCron: false,
 		Jobs:        []*gtsr.CronJob{},
+
+		Handlers: []*gtsr.MessageHandler{{
+			Description: "How to connect to the network drive",
+			Usage:       "network drive",
+			// TODO(nussey): actually scan the words and see if they were asking about the network drive
+			Keywords: []string{"network", "drive"},
+			Action:   ht.networkDrive,
+		}},
 	}
 
 }
@@ -43,21 +108,37 @@ func (ht *HelpTextBot) Teardown() {
 }
 
 func (ht *HelpTextBot) ParseMessage(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
-	if match_NetworkDrive(msg.Text) {
-		// TODO(nussey): send an etherial message first asking if they are curious
-		return messenger.NewMessage(networkDriveText).Send()
-	}
-
 	return nil
 }
 
-func match_NetworkDrive(msg string) bool {
-	// TODO(nussey): actually scan the words and see if they were asking about the network drive
-	msg = strings.ToLower(msg)
-	return strings.Contains(msg, "network") && strings.Contains(msg, "drive")
+func (ht *HelpTextBot) networkDrive(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
+	// TODO(nussey): send an etherial message first asking if they are curious
+	_, err := messenger.NewMessage(ht.current().NetworkDrive).Send()
+	return err
 }
 
-func (ht *HelpTextBot) FAQ(messenger *gtsr.Messenger) error {
-	messenger.NewMessage("Hello, this is FAQ").Send()
+func (ht *HelpTextBot) FAQ(ctx context.Context, messenger *gtsr.Messenger) error {
+	faq := ht.current().FAQ
+	if len(faq) == 0 {
+		_, err := messenger.NewMessage("I don't know any answers yet, ask an officer!").Send()
+		return err
+	}
+
+	var questions []string
+	for _, entry := range faq {
+		questions = append(questions, entry.Question)
+	}
+
+	question, err := messenger.AskChoice("What do you want to know?", questions)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range faq {
+		if entry.Question == question {
+			_, err = messenger.NewMessage(entry.Answer).Send()
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,151 @@
+package poll
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nussey/gtsr-slackbot/gtsr"
+)
+
+type PollBot struct {
+}
+
+const defaultDuration = time.Hour
+
+// poll [anon] [30m]: Where should we eat? | Chipotle | Moe's
+var pollreg = regexp.MustCompile(`(?i)^\s*poll(?P<anon>\s+anon)?(?P<duration>\s+\d+[smh])?\s*:(?P<body>.+)$`)
+
+type poll struct {
+	question  string
+	options   []string
+	anonymous bool
+	duration  time.Duration
+}
+
+func (pb *PollBot) Init() *gtsr.PluginConfig {
+	return &gtsr.PluginConfig{
+		ID:          "poll",
+		Name:        "Poll Bot",
+		Description: "Runs quick polls in channels with live vote counts",
+		Version:     "1.0",
+
+		FeatureConvo: false,
+		Topics:       []*gtsr.ConvoTopic{},
+
+		FeatureCron: false,
+		Jobs:        []*gtsr.CronJob{},
+
+		Handlers: []*gtsr.MessageHandler{{
+			Description: "Starts a poll, optionally anonymous or with a custom length",
+			Usage:       "poll [anon] [30m]: Question | Option | Option",
+			Pattern:     pollreg,
+			Action:      pb.start,
+		}},
+	}
+
+}
+
+func (pb *PollBot) Teardown() {
+
+}
+
+func (pb *PollBot) ParseMessage(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
+	return nil
+}
+
+func (pb *PollBot) start(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
+	p := newPoll(msg)
+
+	if len(p.options) < 2 {
+		_, err := messenger.NewMessage("A poll needs a question and at least two options, like `poll: Lunch? | Pizza | Tacos`").Send()
+		return err
+	}
+
+	out := messenger.NewMessage(p.tally(nil))
+	if len(p.options) > 5 {
+		out.AddDropdown("Vote", p.options)
+	} else {
+		for _, opt := range p.options {
+			out.AddButton(opt)
+		}
+	}
+
+	session, err := messenger.NewGroupSession(out, time.Now().Add(p.duration))
+	if err != nil {
+		return err
+	}
+
+	// Don't hold up the rest of the plugins while people vote
+	go p.run(messenger, session)
+	return nil
+}
+
+func newPoll(msg *gtsr.IncomingMessage) *poll {
+	p := &poll{
+		anonymous: msg.Capture("anon") != "",
+		duration:  defaultDuration,
+	}
+
+	if msg.Capture("duration") != "" {
+		d, err := time.ParseDuration(strings.TrimSpace(msg.Capture("duration")))
+		if err == nil {
+			p.duration = d
+		}
+	}
+
+	parts := strings.Split(msg.Capture("body"), "|")
+	p.question = strings.TrimSpace(parts[0])
+	for _, opt := range parts[1:] {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			p.options = append(p.options, opt)
+		}
+	}
+
+	return p
+}
+
+func (p *poll) run(messenger *gtsr.Messenger, session *gtsr.GroupSession) {
+	for {
+		_, err := session.Next(messenger.Context())
+		if err != nil {
+			break
+		}
+
+		err = session.UpdateText(p.tally(session.Latest()))
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	session.Close()
+	err := session.Message().UpdateText("*Poll closed*\n" + p.tally(session.Latest()))
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// tally renders the question and the current votes. Only the latest
+// vote of each user counts
+func (p *poll) tally(votes map[string]*gtsr.Answer) string {
+	voters := make(map[string][]string)
+	for user, vote := range votes {
+		voters[vote.Text] = append(voters[vote.Text], user)
+	}
+
+	text := "*" + p.question + "*"
+	for _, opt := range p.options {
+		text += fmt.Sprintf("\n• %s: %d", opt, len(voters[opt]))
+		if !p.anonymous && len(voters[opt]) > 0 {
+			sort.Strings(voters[opt])
+			text += " (" + strings.Join(voters[opt], ", ") + ")"
+		}
+	}
+
+	if p.anonymous {
+		text += "\n_Votes are anonymous_"
+	}
+	return text
+}
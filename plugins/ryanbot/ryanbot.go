@@ -1,18 +1,91 @@
 package ryanbot
 
 import (
+	"fmt"
 	"regexp"
+	"sync"
 
 	"github.com/nussey/gtsr-slackbot/gtsr"
 )
 
 type RyanBot struct {
+	// Guards rules, which can be swapped out by Reconfigure
+	mutex sync.RWMutex
+	rules []rule
+
+	settings Settings
+}
+
+// Settings is the ryanbot section of the config file
+type Settings struct {
+	// Defaults to reacting :hmm: to "hmm"
+	Reactions []Reaction
+}
+
+// A Reaction is an emoji added to every message matching Pattern
+type Reaction struct {
+	Pattern string
+	Emoji   string
+}
+
+type rule struct {
+	pattern *regexp.Regexp
+	emoji   string
+}
+
+var defaultReactions = []Reaction{{Pattern: `([Hh])+([Mm])+`, Emoji: "hmm"}}
+
+func (s *Settings) Validate() error {
+	for _, reaction := range s.Reactions {
+		if reaction.Pattern == "" {
+			return fmt.Errorf("reaction %s needs a Pattern", reaction.Emoji)
+		}
+		if reaction.Emoji == "" {
+			return fmt.Errorf("reaction to %q needs an Emoji", reaction.Pattern)
+		}
+		if _, err := regexp.Compile(reaction.Pattern); err != nil {
+			return fmt.Errorf("reaction %s: %s", reaction.Emoji, err)
+		}
+	}
+	return nil
+}
+
+// rules compiles the reactions, already checked by Validate
+func (s *Settings) rules() []rule {
+	reactions := s.Reactions
+	if len(reactions) == 0 {
+		reactions = defaultReactions
+	}
+
+	var rules []rule
+	for _, reaction := range reactions {
+		rules = append(rules, rule{
+			pattern: regexp.MustCompile(reaction.Pattern),
+			emoji:   reaction.Emoji,
+		})
+	}
+	return rules
+}
+
+func (rb *RyanBot) Settings() interface{} {
+	return &rb.settings
 }
 
-var hmmreg = regexp.MustCompile(`([Hh])+([Mm])+`)
+func (rb *RyanBot) Reconfigure(settings interface{}) error {
+	rules := settings.(*Settings).rules()
+
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	rb.rules = rules
+	return nil
+}
 
 func (rb *RyanBot) Init() *gtsr.PluginConfig {
+	rb.rules = rb.settings.rules()
+
 	return &gtsr.PluginConfig{
+		ID:          "ryanbot",
 		Name:        "Ryan Bot",
 		Description: "Allows clippy to immitate Ryan",
 		Version:     "1.0",
@@ -22,6 +95,12 @@ func (rb *RyanBot) Init() *gtsr.PluginConfig {
 
 		FeatureCron: false,
 		Jobs:        []*gtsr.CronJob{},
+
+		Handlers: []*gtsr.MessageHandler{{
+			Description: "Reacts to messages like Ryan would",
+			Usage:       "hmm",
+			Action:      rb.react,
+		}},
 	}
 
 }
@@ -31,13 +110,21 @@ func (rb *RyanBot) Teardown() {
 }
 
 func (rb *RyanBot) ParseMessage(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
-	if match_Hmm(msg.Text) {
-		return msg.AddReaction("hmm")
-	}
-
 	return nil
 }
 
-func match_Hmm(text string) bool {
-	return hmmreg.MatchString(text)
+func (rb *RyanBot) react(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
+	rb.mutex.RLock()
+	rules := rb.rules
+	rb.mutex.RUnlock()
+
+	for _, rule := range rules {
+		if rule.pattern.MatchString(msg.Text) {
+			err := msg.AddReaction(rule.emoji)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
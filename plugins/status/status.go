@@ -0,0 +1,104 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/nussey/gtsr-slackbot/gtsr"
+)
+
+// StatusBot reports how clippy is doing. Version and Commit are meant to
+// be set at build time with -ldflags
+type StatusBot struct {
+	Bot     *gtsr.SlackBot
+	Version string
+	Commit  string
+}
+
+var statusreg = regexp.MustCompile(`(?i)^(status|uptime)\??$`)
+
+func (sb *StatusBot) Init() *gtsr.PluginConfig {
+	status := &gtsr.ConvoTopic{
+		ID:          "status",
+		Label:       "Bot Status",
+		Keywords:    []string{"status", "uptime", "version"},
+		Permissions: &gtsr.Permissions{},
+
+		Action: sb.statusConvo,
+	}
+
+	return &gtsr.PluginConfig{
+		ID:          "status",
+		Name:        "Status Bot",
+		Description: "Reports uptime, build info and how busy clippy is",
+		Version:     "1.0",
+
+		FeatureConvo: true,
+		Topics:       []*gtsr.ConvoTopic{status},
+
+		FeatureCron: false,
+		Jobs:        []*gtsr.CronJob{},
+
+		Handlers: []*gtsr.MessageHandler{{
+			Description: "Shows uptime and build info",
+			Usage:       "status",
+			Pattern:     statusreg,
+			Mention:     true,
+			Action:      sb.status,
+		}},
+	}
+
+}
+
+func (sb *StatusBot) Teardown() {
+
+}
+
+func (sb *StatusBot) ParseMessage(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
+	return nil
+}
+
+func (sb *StatusBot) status(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
+	_, err := messenger.NewMessage(sb.report()).Send()
+	return err
+}
+
+func (sb *StatusBot) statusConvo(ctx context.Context, messenger *gtsr.Messenger) error {
+	_, err := messenger.NewMessage(sb.report()).Send()
+	return err
+}
+
+func (sb *StatusBot) report() string {
+	status := sb.Bot.Status()
+	now := time.Now()
+
+	version := sb.Version
+	if version == "" {
+		version = "dev"
+	}
+	commit := sb.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+
+	connected := "disconnected"
+	if !status.Connected.IsZero() {
+		connected = "connected for " + since(status.Connected, now)
+	}
+
+	text := fmt.Sprintf("*Status*\n• Up for %s\n• Version %s (commit `%s`), built with %s", since(status.Started, now), version, commit, runtime.Version())
+	text += fmt.Sprintf("\n• Slack %s, %d reconnects", connected, status.Reconnects)
+	text += fmt.Sprintf("\n• %d plugins, %d cron jobs", status.Plugins, status.CronJobs)
+	text += fmt.Sprintf("\n• %d conversations going, %d waiting", status.Conversations, status.QueuedConversations)
+	text += fmt.Sprintf("\n• %d/%d workers busy, %d messages queued (most %d), %d plugin timeouts",
+		status.Dispatch.Busy, status.Dispatch.Workers, status.Dispatch.Queued, status.Dispatch.MaxQueued, status.Dispatch.Timeouts)
+	return text
+}
+
+// since renders the time from t to now rounded to the second
+func since(t time.Time, now time.Time) string {
+	return now.Sub(t).Round(time.Second).String()
+}
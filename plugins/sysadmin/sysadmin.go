@@ -1,23 +1,50 @@
 package sysadmin
 
 import (
+	"context"
+	"regexp"
 	"strings"
 
 	"github.com/nussey/gtsr-slackbot/gtsr"
 )
 
 type SysAdminBot struct {
+	Bot *gtsr.SlackBot
 }
 
+var pingreg = regexp.MustCompile(`(?i)^ping$`)
+
+// disable ryanbot in #electrical
+var switchreg = regexp.MustCompile(`(?i)^(?P<action>enable|disable)\s+(?P<plugin>\w+)(?:\s+in\s+(?:<#(?P<channel>\w+)(?:\|[^>]*)?>|#?(?P<name>[\w-]+)))?\s*$`)
+
 func (sa *SysAdminBot) Init() *gtsr.PluginConfig {
 	debug := &gtsr.ConvoTopic{
 		ID:          "debug",
 		Label:       "Debugger",
+		Keywords:    []string{"hackerman"},
 		Permissions: &gtsr.Permissions{},
 
 		Action: sa.debugger,
 	}
 
+	killer := &gtsr.ConvoTopic{
+		ID:          "killer",
+		Label:       "Conversation Killer",
+		Keywords:    []string{"kill"},
+		Permissions: &gtsr.Permissions{Admin: true},
+
+		Action: sa.killer,
+	}
+
+	switcher := &gtsr.ConvoTopic{
+		ID:          "plugins",
+		Label:       "Plugin Switches",
+		Keywords:    []string{"enable", "disable", "plugin"},
+		Permissions: &gtsr.Permissions{Admin: true},
+
+		Action: sa.switcher,
+	}
+
 	poker := &gtsr.CronJob{
 		ID:   "poker",
 		Name: "Developer Poker",
@@ -27,26 +54,43 @@ func (sa *SysAdminBot) Init() *gtsr.PluginConfig {
 	}
 
 	return &gtsr.PluginConfig{
+		ID:          "sysadmin",
 		Name:        "SysAdmin Bot",
 		Description: "Helps plugin developers see what is going on inside clippy",
 		Version:     "1.0",
 
 		FeatureConvo: true,
-		Topics:       []*gtsr.ConvoTopic{debug},
+		Topics:       []*gtsr.ConvoTopic{debug, killer, switcher},
 
 		FeatureCron: true,
 		Jobs:        []*gtsr.CronJob{poker},
+
+		Handlers: []*gtsr.MessageHandler{{
+			Description: "Checks that I'm alive",
+			Usage:       "ping",
+			Pattern:     pingreg,
+			Action:      sa.ping,
+		}, {
+			Description: "Turns a plugin on or off, everywhere or in one channel (admins only)",
+			Usage:       "disable ryanbot in #electrical",
+			Pattern:     switchreg,
+			Mention:     true,
+			Action:      sa.toggle,
+		}},
 	}
 
 }
 
-func (sa *SysAdminBot) debugger(messenger *gtsr.Messenger) error {
+func (sa *SysAdminBot) debugger(ctx context.Context, messenger *gtsr.Messenger) error {
 	msg := messenger.NewMessage("What's up hackerman?")
 	msg.AddButton("Ping").AddButton("Pong")
 	msg.AddDropdown("Foobar", []string{"bar", "foo"})
 	msg.Send()
 
 	cont, rsp := messenger.AwaitResponse()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if !cont {
 		messenger.NewMessage("Really? You ignoring me?").Send()
 		return nil
@@ -57,12 +101,42 @@ func (sa *SysAdminBot) debugger(messenger *gtsr.Messenger) error {
 	return nil
 }
 
+func (sa *SysAdminBot) killer(ctx context.Context, messenger *gtsr.Messenger) error {
+	// The topic is admin only, but conversations can be started without
+	// going through the middleware so check again
+	user := strings.TrimPrefix(messenger.ChannelName(), "@")
+	if !sa.Bot.Permissions(user).Admin {
+		_, err := messenger.NewMessage("Sorry, only admins can kill conversations").Send()
+		return err
+	}
+
+	messenger.NewMessage("Whose conversation should I kill?").Send()
+
+	cont, rsp := messenger.AwaitResponse()
+	if !cont {
+		return ctx.Err()
+	}
+
+	rsp = strings.TrimSpace(rsp)
+	if rsp == "" {
+		_, err := messenger.NewMessage("I need a name to do that").Send()
+		return err
+	}
+
+	if !messenger.Global().CancelConversation(rsp) {
+		_, err := messenger.NewMessage(rsp + " isn't talking to me right now").Send()
+		return err
+	}
+
+	_, err := messenger.NewMessage("Done, " + rsp + " is free").Send()
+	return err
+}
+
 func (sa *SysAdminBot) poke(gm *gtsr.GlobalMessenger) error {
-	gm.NewConversation("nussey", func(messenger *gtsr.Messenger) error {
-		return messenger.NewMessage("CODE FASTER!").Send()
+	return gm.NewConversation("nussey", func(ctx context.Context, messenger *gtsr.Messenger) error {
+		_, err := messenger.NewMessage("CODE FASTER!").Send()
+		return err
 	})
-
-	return nil
 }
 
 func (sa *SysAdminBot) Teardown() {
@@ -70,16 +144,93 @@ func (sa *SysAdminBot) Teardown() {
 }
 
 func (sa *SysAdminBot) ParseMessage(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
-	if match_ping(msg.Text) {
-		// TODO(nussey): send an etherial message first asking if they are curious
-		return messenger.NewMessage("pong").Send()
+	return nil
+}
+
+func (sa *SysAdminBot) ping(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
+	_, err := messenger.NewMessage("pong").Send()
+	return err
+}
+
+func (sa *SysAdminBot) switcher(ctx context.Context, messenger *gtsr.Messenger) error {
+	_, err := messenger.NewMessage(sa.Bot.PluginSwitches()).Send()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	id, err := messenger.AskChoice("Which plugin?", sa.Bot.PluginIDs())
+	if err != nil {
+		return err
+	}
+	if id == "sysadmin" {
+		_, err = messenger.NewMessage("I need sysadmin to take orders, it stays on").Send()
+		return err
+	}
+
+	action, err := messenger.AskChoice("Turn "+id+" on or off?", []string{"On", "Off"})
+	if err != nil {
+		return err
+	}
+
+	where, err := messenger.AskChoice("Where?", []string{"Everywhere", "One channel"})
+	if err != nil {
+		return err
+	}
+
+	var channel string
+	if where == "One channel" {
+		channel, err = messenger.AskChannel("Which channel?")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = sa.Bot.SetPluginEnabled(id, channel, action == "On")
+	if err != nil {
+		_, err = messenger.NewMessage("That didn't work: " + err.Error()).Send()
+		return err
+	}
+
+	_, err = messenger.NewMessage("Done!\n" + sa.Bot.PluginSwitches()).Send()
+	return err
 }
 
-func match_ping(msg string) bool {
-	// TODO(nussey): actually scan the words and see if they were asking about the network drive
-	msg = strings.ToLower(msg)
-	return msg == "ping"
+func (sa *SysAdminBot) toggle(msg *gtsr.IncomingMessage, messenger *gtsr.Messenger) error {
+	if !sa.Bot.Permissions(msg.User()).Admin {
+		_, err := messenger.NewMessage("Sorry, only admins can turn plugins on and off").Send()
+		return err
+	}
+
+	id := strings.ToLower(msg.Capture("plugin"))
+	on := strings.EqualFold(msg.Capture("action"), "enable")
+	if id == "sysadmin" && !on {
+		_, err := messenger.NewMessage("I need sysadmin to take orders, it stays on").Send()
+		return err
+	}
+
+	channel := msg.Capture("channel")
+	if channel == "" {
+		channel = msg.Capture("name")
+	}
+
+	err := sa.Bot.SetPluginEnabled(id, channel, on)
+	if err != nil {
+		_, err = messenger.NewMessage("That didn't work: " + err.Error()).Send()
+		return err
+	}
+
+	where := "everywhere"
+	if channel != "" {
+		where = "in that channel"
+	}
+	state := "off"
+	if on {
+		state = "on"
+	}
+	text := id + " is now " + state + " " + where
+	if on && !sa.Bot.PluginEnabled(id, channel) {
+		text += ", but it is still off everywhere"
+	}
+	_, err = messenger.NewMessage(text).Send()
+	return err
 }